@@ -80,5 +80,16 @@ func handle() {
 
 	driver := service.NewOvirtCSIDriver(ovirtClient, mgr.GetClient(), nodeId)
 
+	// the controller reads the persistent volumes through the cache of the manager
+	if *nodeName == "" {
+		stop := make(chan struct{})
+		go func() {
+			if err := mgr.Start(stop); err != nil {
+				klog.Fatal(err)
+			}
+		}()
+		go driver.ControllerService.SyncDiskProfiles(stop)
+	}
+
 	driver.Run(*endpoint)
 }
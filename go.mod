@@ -3,7 +3,8 @@ module github.com/ovirt/csi-driver
 go 1.13
 
 require (
-	github.com/container-storage-interface/spec v1.2.0
+	github.com/container-storage-interface/spec v1.3.0
+	github.com/golang/protobuf v1.3.3
 	github.com/kubernetes-csi/csi-lib-utils v0.7.0
 	github.com/onsi/ginkgo v1.10.2
 	github.com/onsi/gomega v1.7.0
@@ -18,7 +19,7 @@ require (
 	golang.org/x/xerrors v0.0.0-20191204190536-9bdfabe68543 // indirect
 	google.golang.org/grpc v1.29.1
 	gopkg.in/yaml.v2 v2.2.7
-	k8s.io/api v0.17.1 // indirect
+	k8s.io/api v0.17.1
 	k8s.io/apiextensions-apiserver v0.17.1 // indirect
 	k8s.io/apimachinery v0.17.1
 	k8s.io/client-go v12.0.0+incompatible
@@ -0,0 +1,55 @@
+package ovirt
+
+import (
+	"context"
+	"regexp"
+)
+
+// CorrelationIdHeader carries the correlation id of a request to the engine,
+// which tags its jobs, events and engine.log lines with it
+const CorrelationIdHeader = "Correlation-Id"
+
+// correlationIdMaxLength is the longest correlation id the engine accepts
+const correlationIdMaxLength = 50
+
+// defaultCorrelationId is used for calls not issued on behalf of a request
+const defaultCorrelationId = "ovirt-csi-driver"
+
+// correlationIdForbidden matches what the engine does not accept in a correlation id
+var correlationIdForbidden = regexp.MustCompile(`[^\w-]`)
+
+type correlationIdKey struct{}
+
+// CorrelationId builds a correlation id from the object a request acts on,
+// e.g. a disk id, and a suffix naming the request. The object is shortened
+// from the front to fit, since ids differ the most at their end.
+func CorrelationId(object string, suffix string) string {
+	object = correlationIdForbidden.ReplaceAllString(object, "_")
+	suffix = correlationIdForbidden.ReplaceAllString(suffix, "_")
+	if object == "" {
+		return suffix
+	}
+	if max := correlationIdMaxLength - len(suffix) - 1; len(object) > max {
+		object = object[len(object)-max:]
+	}
+	return object + "-" + suffix
+}
+
+// WithCorrelationId returns a context whose engine calls carry the correlation id
+func WithCorrelationId(ctx context.Context, correlationId string) context.Context {
+	return context.WithValue(ctx, correlationIdKey{}, correlationId)
+}
+
+// CorrelationIdOf returns the correlation id of the context
+func CorrelationIdOf(ctx context.Context) string {
+	if correlationId, ok := ctx.Value(correlationIdKey{}).(string); ok && correlationId != "" {
+		return correlationId
+	}
+	return defaultCorrelationId
+}
+
+// CorrelationHeader returns the header and the value to set on an engine
+// request, as in request.Header(CorrelationHeader(ctx))
+func CorrelationHeader(ctx context.Context) (string, string) {
+	return CorrelationIdHeader, CorrelationIdOf(ctx)
+}
@@ -0,0 +1,36 @@
+package ovirt_test
+
+import (
+	"context"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	"github.com/ovirt/csi-driver/internal/ovirt"
+)
+
+var _ = Describe("Correlation id", func() {
+	DescribeTable("builds correlation ids",
+		func(object string, suffix string, expected string) {
+			Expect(ovirt.CorrelationId(object, suffix)).To(Equal(expected))
+		},
+		Entry("disk id", "3f2b4c1e-9a7d-4e21-8c55-0d6f1b2a9e47", "attach", "3f2b4c1e-9a7d-4e21-8c55-0d6f1b2a9e47-attach"),
+		Entry("forbidden characters", "pvc/a.b c", "create", "pvc_a_b_c-create"),
+		Entry("no object", "", "list", "list"),
+		Entry("too long, shortened from the front", strings.Repeat("a", 10)+strings.Repeat("b", 40), "delete",
+			strings.Repeat("a", 3)+strings.Repeat("b", 40)+"-delete"),
+	)
+
+	It("carries the correlation id in the context", func() {
+		ctx := ovirt.WithCorrelationId(context.Background(), "disk-attach")
+		Expect(ovirt.CorrelationIdOf(ctx)).To(Equal("disk-attach"))
+		header, value := ovirt.CorrelationHeader(ctx)
+		Expect(header).To(Equal(ovirt.CorrelationIdHeader))
+		Expect(value).To(Equal("disk-attach"))
+	})
+
+	It("falls back to the driver outside of a request", func() {
+		Expect(ovirt.CorrelationIdOf(context.Background())).To(Equal("ovirt-csi-driver"))
+	})
+})
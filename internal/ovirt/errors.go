@@ -0,0 +1,92 @@
+package ovirt
+
+import (
+	"errors"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+
+	ovirtsdk "github.com/ovirt/go-ovirt"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// responseCodePattern finds the HTTP response code the SDK puts in the
+// message of an engine fault
+var responseCodePattern = regexp.MustCompile(`HTTP response code is "(\d+)"`)
+
+// exhaustedPattern matches the faults of the engine refusing a disk because its
+// quota is exceeded or its storage domain is low on space
+var exhaustedPattern = regexp.MustCompile(`quota[^.]*exceeded|exceeded[^.]*quota|low disk space|not enough (free )?(disk |storage )?space`)
+
+// lockedPattern matches the faults of the engine refusing to act on an entity
+// another operation holds
+var lockedPattern = regexp.MustCompile(`locked|in progress`)
+
+// Error translates an error of the SDK to a gRPC status, so that the sidecars
+// can tell what to retry:
+//   - 404 is NotFound and 401 and 403 are Unauthenticated
+//   - an exceeded quota or a storage domain low on space is ResourceExhausted
+//   - a locked entity and other 409 conflicts are Aborted
+//   - a 400 is InvalidArgument, as the engine failed to validate the request
+//   - an unreachable or unavailable engine is Unavailable
+//   - other engine faults are Internal
+//
+// Statuses pass unchanged, as do errors not coming from the engine.
+func Error(err error) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+
+	switch err.(type) {
+	case *ovirtsdk.NotFoundError:
+		return status.Error(codes.NotFound, err.Error())
+	case *ovirtsdk.AuthError:
+		return status.Error(codes.Unauthenticated, err.Error())
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return status.Error(codes.Unavailable, err.Error())
+	}
+
+	code, ok := responseCode(err)
+	if !ok {
+		return err
+	}
+	message := strings.ToLower(err.Error())
+	switch {
+	case IsExhausted(err):
+		return status.Error(codes.ResourceExhausted, err.Error())
+	case lockedPattern.MatchString(message) || code == 409:
+		return status.Error(codes.Aborted, err.Error())
+	case code == 400:
+		return status.Error(codes.InvalidArgument, err.Error())
+	case code == 502 || code == 503 || code == 504:
+		return status.Error(codes.Unavailable, err.Error())
+	}
+	return status.Error(codes.Internal, err.Error())
+}
+
+// IsExhausted tells whether the error is the engine refusing a disk because its
+// quota is exceeded or its storage domain is low on space
+func IsExhausted(err error) bool {
+	if err == nil {
+		return false
+	}
+	code, ok := responseCode(err)
+	return ok && (code == 400 || code == 409) && exhaustedPattern.MatchString(strings.ToLower(err.Error()))
+}
+
+// responseCode returns the HTTP response code of an engine fault
+func responseCode(err error) (int, bool) {
+	match := responseCodePattern.FindStringSubmatch(err.Error())
+	if match == nil {
+		return 0, false
+	}
+	code, _ := strconv.Atoi(match[1])
+	return code, true
+}
@@ -0,0 +1,59 @@
+package ovirt_test
+
+import (
+	"errors"
+	"net"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	"github.com/ovirt/csi-driver/internal/ovirt"
+	ovirtsdk "github.com/ovirt/go-ovirt"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// fault is an engine fault as the SDK reports it
+func fault(detail string, code string) error {
+	return errors.New(`Fault reason is "Operation Failed". Fault detail is "[` + detail + `]". ` +
+		`HTTP response code is "` + code + `". HTTP response message is "` + code + `".`)
+}
+
+var _ = Describe("Error", func() {
+	It("passes nil", func() {
+		Expect(ovirt.Error(nil)).To(BeNil())
+	})
+
+	DescribeTable("translates errors to status codes",
+		func(err error, expected codes.Code) {
+			Expect(status.Code(ovirt.Error(err))).To(Equal(expected))
+		},
+		Entry("not found", &ovirtsdk.NotFoundError{}, codes.NotFound),
+		Entry("auth", &ovirtsdk.AuthError{}, codes.Unauthenticated),
+		Entry("unreachable engine", &net.OpError{Op: "dial", Err: errors.New("connection refused")}, codes.Unavailable),
+		Entry("quota exceeded", fault("Cannot add Virtual Disk. Storage Quota limit exceeded.", "409"), codes.ResourceExhausted),
+		Entry("low disk space", fault("Cannot add Virtual Disk. Low disk space on Storage Domain data.", "409"), codes.ResourceExhausted),
+		Entry("missing quota", fault("Cannot add Virtual Disk. Quota team does not exist.", "400"), codes.InvalidArgument),
+		Entry("missing storage domain", fault("Cannot add Virtual Disk. Storage Domain doesn't exist.", "400"), codes.InvalidArgument),
+		Entry("locked disk", fault("Cannot remove Virtual Disk. Disk is locked.", "409"), codes.Aborted),
+		Entry("conflict", fault("Cannot attach Virtual Disk. The disk is already attached to VM node-1.", "409"), codes.Aborted),
+		Entry("engine unavailable", fault("Service Unavailable", "503"), codes.Unavailable),
+		Entry("engine failure", fault("Internal Server Error", "500"), codes.Internal),
+		Entry("status", status.Error(codes.OutOfRange, "too small"), codes.OutOfRange),
+		Entry("not from the engine", errors.New("mount failed"), codes.Unknown),
+	)
+})
+
+var _ = Describe("IsExhausted", func() {
+	DescribeTable("matches only a lack of quota or space",
+		func(err error, expected bool) {
+			Expect(ovirt.IsExhausted(err)).To(Equal(expected))
+		},
+		Entry("nil", nil, false),
+		Entry("quota exceeded", fault("Cannot add Virtual Disk. Storage Quota limit exceeded.", "409"), true),
+		Entry("low disk space", fault("Cannot add Virtual Disk. Low disk space on Storage Domain data.", "400"), true),
+		Entry("missing quota", fault("Cannot add Virtual Disk. Quota team does not exist.", "400"), false),
+		Entry("quota in a server error", fault("Failed to compute the quota exceeded limit.", "500"), false),
+		Entry("not from the engine", errors.New("quota exceeded"), false),
+	)
+})
@@ -0,0 +1,13 @@
+package ovirt_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestOvirt(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Ovirt Suite")
+}
@@ -0,0 +1,146 @@
+package ovirt
+
+import (
+	"context"
+	"time"
+
+	ovirtsdk "github.com/ovirt/go-ovirt"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// PollInterval is how often the engine is asked whether an asynchronous
+// operation is over
+var PollInterval = 5 * time.Second
+
+// WaitFor polls the condition until it is done or fails. When the context is
+// done first it returns DeadlineExceeded, naming what was waited for.
+func WaitFor(ctx context.Context, what string, condition func() (bool, error)) error {
+	for {
+		done, err := condition()
+		if err != nil || done {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return status.Errorf(codes.DeadlineExceeded, "timed out waiting for %s", what)
+		case <-time.After(PollInterval):
+		}
+	}
+}
+
+// WaitForDisk polls the disk until it is no longer locked by a create, a copy
+// or a resize, and returns it. An illegal disk is an Internal error.
+func WaitForDisk(ctx context.Context, connection *ovirtsdk.Connection, diskId string) (*ovirtsdk.Disk, error) {
+	var disk *ovirtsdk.Disk
+	err := WaitFor(ctx, "disk "+diskId+" to unlock", func() (bool, error) {
+		response, err := connection.SystemService().DisksService().DiskService(diskId).Get().Header(CorrelationHeader(ctx)).Send()
+		if err != nil {
+			return false, err
+		}
+		disk = response.MustDisk()
+		switch diskStatus, _ := disk.Status(); diskStatus {
+		case ovirtsdk.DISKSTATUS_OK:
+			return true, nil
+		case ovirtsdk.DISKSTATUS_ILLEGAL:
+			return false, status.Errorf(codes.Internal, "disk %s is illegal", diskId)
+		}
+		return false, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return disk, nil
+}
+
+// WaitForDiskRemoved polls the disk until the engine no longer knows it
+func WaitForDiskRemoved(ctx context.Context, connection *ovirtsdk.Connection, diskId string) error {
+	return WaitFor(ctx, "disk "+diskId+" to be removed", func() (bool, error) {
+		_, err := connection.SystemService().DisksService().DiskService(diskId).Get().Header(CorrelationHeader(ctx)).Send()
+		if _, ok := err.(*ovirtsdk.NotFoundError); ok {
+			return true, nil
+		}
+		return false, err
+	})
+}
+
+// WaitForDiskAttachment polls the attachment until it is active and its disk
+// is unlocked, and returns it
+func WaitForDiskAttachment(ctx context.Context, connection *ovirtsdk.Connection, vmId string, attachmentId string) (*ovirtsdk.DiskAttachment, error) {
+	var attachment *ovirtsdk.DiskAttachment
+	err := WaitFor(ctx, "disk attachment "+attachmentId+" of VM "+vmId+" to activate", func() (bool, error) {
+		response, err := connection.SystemService().VmsService().VmService(vmId).
+			DiskAttachmentsService().
+			AttachmentService(attachmentId).
+			Get().
+			Follow("disk").
+			Header(CorrelationHeader(ctx)).
+			Send()
+		if err != nil {
+			return false, err
+		}
+		attachment = response.MustAttachment()
+		active, _ := attachment.Active()
+		diskStatus, _ := attachment.MustDisk().Status()
+		if diskStatus == ovirtsdk.DISKSTATUS_ILLEGAL {
+			return false, status.Errorf(codes.Internal, "disk %s is illegal", attachment.MustDisk().MustId())
+		}
+		return active && diskStatus == ovirtsdk.DISKSTATUS_OK, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return attachment, nil
+}
+
+// WaitForDiskAttachmentRemoved polls the attachment until the disk is detached from the VM
+func WaitForDiskAttachmentRemoved(ctx context.Context, connection *ovirtsdk.Connection, vmId string, attachmentId string) error {
+	return WaitFor(ctx, "disk attachment "+attachmentId+" of VM "+vmId+" to be removed", func() (bool, error) {
+		_, err := connection.SystemService().VmsService().VmService(vmId).
+			DiskAttachmentsService().
+			AttachmentService(attachmentId).
+			Get().
+			Header(CorrelationHeader(ctx)).
+			Send()
+		if _, ok := err.(*ovirtsdk.NotFoundError); ok {
+			return true, nil
+		}
+		return false, err
+	})
+}
+
+// WaitForSnapshot polls the VM snapshot until it is no longer locked, and returns it
+func WaitForSnapshot(ctx context.Context, connection *ovirtsdk.Connection, vmId string, snapshotId string) (*ovirtsdk.Snapshot, error) {
+	var snapshot *ovirtsdk.Snapshot
+	err := WaitFor(ctx, "snapshot "+snapshotId+" of VM "+vmId, func() (bool, error) {
+		response, err := connection.SystemService().VmsService().VmService(vmId).
+			SnapshotsService().
+			SnapshotService(snapshotId).
+			Get().
+			Header(CorrelationHeader(ctx)).
+			Send()
+		if err != nil {
+			return false, err
+		}
+		snapshot = response.MustSnapshot()
+		snapshotStatus, _ := snapshot.SnapshotStatus()
+		return snapshotStatus != ovirtsdk.SNAPSHOTSTATUS_LOCKED, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return snapshot, nil
+}
+
+// WaitForVmImageUnlocked polls the VM until its disks are no longer locked by a copy
+func WaitForVmImageUnlocked(ctx context.Context, connection *ovirtsdk.Connection, vmId string) error {
+	return WaitFor(ctx, "the disks of VM "+vmId+" to unlock", func() (bool, error) {
+		vm, err := connection.SystemService().VmsService().VmService(vmId).Get().Header(CorrelationHeader(ctx)).Send()
+		if err != nil {
+			return false, err
+		}
+		vmStatus, _ := vm.MustVm().Status()
+		return vmStatus != ovirtsdk.VMSTATUS_IMAGE_LOCKED, nil
+	})
+}
@@ -1,7 +1,7 @@
 package service
 
 import (
-	"strconv"
+	"sort"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
 	"github.com/ovirt/csi-driver/internal/ovirt"
@@ -17,17 +17,50 @@ import (
 const (
 	ParameterStorageDomainName = "storageDomainName"
 	ParameterThinProvisioning  = "thinProvisioning"
+	ParameterShareable         = "shareable"
+	ParameterOvercommitRatio   = "overcommitRatio"
+	// ParameterStorageDomainSelection is the policy picking a storage domain when none is named
+	ParameterStorageDomainSelection = "storageDomainSelection"
+	// ParameterStorageDomainLabel is matched against the storage domain comments by the label policy
+	ParameterStorageDomainLabel = "storageDomainLabel"
+	// ParameterFormat is the disk format, raw or cow
+	ParameterFormat = "format"
+	// ParameterInterface is the interface the disk is attached with, virtio or virtio_scsi
+	ParameterInterface = "interface"
+	// ParameterSparse is the allocation of the disk, thin when true
+	ParameterSparse = "sparse"
+	// ParameterDiskProfileName is the disk profile of the storage domain the disk gets
+	ParameterDiskProfileName = "diskProfileName"
+	// ParameterDiskProfileId is the disk profile by id, instead of by name
+	ParameterDiskProfileId = "diskProfileId"
+	// ParameterQuotaName is the quota of the data center the disk is accounted to
+	ParameterQuotaName = "quotaName"
+	// ParameterQuotaId is the quota by id, instead of by name
+	ParameterQuotaId = "quotaId"
 )
 
+// diskDescription marks the disks created by the driver, only those are listed by ListVolumes
+const diskDescription = "csi.ovirt.org"
+
 //ControllerService implements the controller interface
 type ControllerService struct {
-	ovirtClient *ovirt.Client
-	client      client.Client
+	// storageDomainCounter takes turns between storage domains for the round robin selection.
+	// It is first to stay aligned for atomic access.
+	storageDomainCounter uint64
+	ovirtClient          *ovirt.Client
+	client               client.Client
 }
 
 var ControllerCaps = []csi.ControllerServiceCapability_RPC_Type{
 	csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME,
 	csi.ControllerServiceCapability_RPC_PUBLISH_UNPUBLISH_VOLUME, // attach/detach
+	csi.ControllerServiceCapability_RPC_CREATE_DELETE_SNAPSHOT,
+	csi.ControllerServiceCapability_RPC_LIST_SNAPSHOTS,
+	csi.ControllerServiceCapability_RPC_CLONE_VOLUME,
+	csi.ControllerServiceCapability_RPC_EXPAND_VOLUME,
+	csi.ControllerServiceCapability_RPC_LIST_VOLUMES,
+	csi.ControllerServiceCapability_RPC_LIST_VOLUMES_PUBLISHED_NODES,
+	csi.ControllerServiceCapability_RPC_GET_CAPACITY,
 }
 
 //CreateVolume creates the disk for the request, unattached from any VM
@@ -40,37 +73,66 @@ func (c *ControllerService) CreateVolume(ctx context.Context, req *csi.CreateVol
 		return nil, err
 	}
 
-	diskByName, err := conn.SystemService().DisksService().List().Search(req.Name).Send()
+	params, err := parseVolumeParameters(req.Parameters)
+	if err != nil {
+		return nil, err
+	}
+	size, err := volumeSize(req.CapacityRange)
 	if err != nil {
 		return nil, err
 	}
+	if err := validateStorageDomain(ctx, conn, params); err != nil {
+		return nil, err
+	}
 
-	// if exists we're done
-	if disks, ok := diskByName.Disks(); ok && len(disks.Slice()) == 1 {
-		disk := disks.Slice()[0]
-		return &csi.CreateVolumeResponse{
-			Volume: &csi.Volume{
-				CapacityBytes:      disk.MustProvisionedSize(),
-				VolumeId:           disk.MustId(),
-				VolumeContext:      nil,
-				ContentSource:      nil,
-				AccessibleTopology: nil,
-			},
-		}, nil
+	// multi-node access needs a shareable disk, whatever the content source
+	if !params.shareable {
+		for _, capability := range req.VolumeCapabilities {
+			if isMultiNodeAccessMode(capability.GetAccessMode().GetMode()) {
+				return nil, status.Errorf(codes.InvalidArgument,
+					"access mode %s requires the %s parameter", capability.GetAccessMode().GetMode(), ParameterShareable)
+			}
+		}
+	}
+
+	// idempotence first - an earlier call may have created the disk, wherever it placed it
+	if req.VolumeContentSource == nil {
+		existing, err := existingVolume(ctx, conn, req, params, size)
+		if err != nil || existing != nil {
+			return existing, err
+		}
+	}
+
+	if snapshotSource := req.VolumeContentSource.GetSnapshot(); snapshotSource != nil {
+		return c.createVolumeFromSnapshot(ctx, conn, req, snapshotSource.SnapshotId, params, size)
+	}
+
+	if volumeSource := req.VolumeContentSource.GetVolume(); volumeSource != nil {
+		return c.createVolumeFromVolume(ctx, conn, req, volumeSource.VolumeId, params, size)
 	}
 
-	// TODO rgolan the default in case of error would be non thin - change it?
-	thinProvisioning, _ := strconv.ParseBool(req.Parameters[ParameterThinProvisioning])
+	placement, err := c.placeNewVolume(ctx, conn, req, params, "")
+	if err != nil {
+		return nil, err
+	}
 
 	// creating the disk
-	disk, err := ovirtsdk.NewDiskBuilder().
+	diskBuilder := ovirtsdk.NewDiskBuilder().
 		Name(req.Name).
-		StorageDomainsBuilderOfAny(*ovirtsdk.NewStorageDomainBuilder().Name(req.Parameters[ParameterStorageDomainName])).
-		ProvisionedSize(req.CapacityRange.GetRequiredBytes()).
+		Description(diskDescription).
+		StorageDomainsBuilderOfAny(*ovirtsdk.NewStorageDomainBuilder().Id(placement.storageDomain.MustId())).
+		ProvisionedSize(size).
 		ReadOnly(false).
-		Format(ovirtsdk.DISKFORMAT_COW).
-		Sparse(thinProvisioning).
-		Build()
+		Format(params.format).
+		Sparse(params.sparse).
+		Shareable(params.shareable)
+	if placement.diskProfile != nil {
+		diskBuilder.DiskProfile(placement.diskProfile)
+	}
+	if placement.quota != nil {
+		diskBuilder.Quota(placement.quota)
+	}
+	disk, err := diskBuilder.Build()
 
 	if err != nil {
 		// failed to construct the disk
@@ -80,16 +142,387 @@ func (c *ControllerService) CreateVolume(ctx context.Context, req *csi.CreateVol
 	createDisk, err := conn.SystemService().DisksService().
 		Add().
 		Disk(disk).
+		Header(ovirt.CorrelationHeader(ctx)).
 		Send()
 	if err != nil {
 		// failed to create the disk
 		klog.Errorf("Failed creating disk %s", req.Name)
+		return nil, quotaError(ctx, conn, placement, err)
+	}
+
+	// the disk is locked until it is created, publishing it before would fail
+	createdDisk, err := ovirt.WaitForDisk(ctx, conn, createDisk.MustDisk().MustId())
+	if err != nil {
+		return nil, err
+	}
+	volumeContext, err := diskVolumeContext(ctx, conn, createdDisk, placement, params.diskInterface)
+	if err != nil {
+		return nil, err
+	}
+	return &csi.CreateVolumeResponse{
+		Volume: &csi.Volume{
+			CapacityBytes:      createdDisk.MustProvisionedSize(),
+			VolumeId:           newVolumeHandle(placement, createdDisk.MustId()).String(),
+			VolumeContext:      volumeContext,
+			AccessibleTopology: placement.topology,
+		},
+	}, nil
+}
+
+// placeNewVolume places a volume about to be created, within the data center of its content
+// source when the ID of that data center is given, and resolves its disk profile and quota there
+func (c *ControllerService) placeNewVolume(
+	ctx context.Context, conn *ovirtsdk.Connection, req *csi.CreateVolumeRequest, params *volumeParameters,
+	dataCenterId string) (*volumePlacement, error) {
+
+	placement, err := c.placeVolume(ctx, conn, req.AccessibilityRequirements, params, dataCenterId)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateDiskLayout(placement.storageDomain, params); err != nil {
+		return nil, err
+	}
+	placement.diskProfile, err = resolveDiskProfile(ctx, conn, placement, params)
+	if err != nil {
+		return nil, err
+	}
+	placement.quota, err = resolveQuota(ctx, conn, placement, params)
+	if err != nil {
+		return nil, err
+	}
+	return placement, nil
+}
+
+// existingVolume returns the volume of a disk already named like the request, or nil when there
+// is none. The disk must match the request, otherwise the name is taken and AlreadyExists is
+// returned. A disk still locked by the call that created it is waited for.
+func existingVolume(
+	ctx context.Context, conn *ovirtsdk.Connection, req *csi.CreateVolumeRequest, params *volumeParameters,
+	size int64) (*csi.CreateVolumeResponse, error) {
+
+	disk, err := diskByName(ctx, conn, req.Name)
+	if err != nil || disk == nil {
+		return nil, err
+	}
+	// a direct LUN disk is not one the driver creates
+	storageDomains, ok := disk.StorageDomains()
+	if !ok || len(storageDomains.Slice()) == 0 {
+		return nil, status.Errorf(codes.AlreadyExists, "disk %s already exists without a storage domain", req.Name)
+	}
+	storageDomain := storageDomains.Slice()[0]
+	// the disk may have been placed on any storage domain by an earlier call
+	placement, err := diskPlacement(ctx, conn, disk, req.AccessibilityRequirements)
+	if err != nil {
+		return nil, err
+	}
+
+	if diskStatus, _ := disk.Status(); diskStatus == ovirtsdk.DISKSTATUS_LOCKED {
+		klog.Infof("Disk %s is still being created, waiting for it", req.Name)
+		disk, err = ovirt.WaitForDisk(ctx, conn, disk.MustId())
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	diskSize := disk.MustProvisionedSize()
+	format, _ := disk.Format()
+	shareable, _ := disk.Shareable()
+	switch {
+	case params.storageDomainName != "" && storageDomain.MustName() != params.storageDomainName:
+		return nil, status.Errorf(codes.AlreadyExists,
+			"disk %s already exists on storage domain %s instead of %s", req.Name, storageDomain.MustName(), params.storageDomainName)
+	case placement == nil:
+		return nil, status.Errorf(codes.AlreadyExists,
+			"disk %s already exists on storage domain %s, outside of the accessibility requirements", req.Name, storageDomain.MustName())
+	case diskSize < size:
+		return nil, status.Errorf(codes.AlreadyExists,
+			"disk %s already exists with %d bytes, less than the required %d", req.Name, diskSize, size)
+	case req.CapacityRange.GetLimitBytes() > 0 && diskSize > req.CapacityRange.GetLimitBytes():
+		return nil, status.Errorf(codes.AlreadyExists,
+			"disk %s already exists with %d bytes, more than the limit %d", req.Name, diskSize, req.CapacityRange.GetLimitBytes())
+	case format != params.format:
+		return nil, status.Errorf(codes.AlreadyExists,
+			"disk %s already exists with format %s instead of %s", req.Name, format, params.format)
+	case shareable != params.shareable:
+		return nil, status.Errorf(codes.AlreadyExists,
+			"disk %s already exists with shareable %t instead of %t", req.Name, shareable, params.shareable)
+	}
+
+	volumeContext, err := diskVolumeContext(ctx, conn, disk, placement, params.diskInterface)
+	if err != nil {
 		return nil, err
 	}
 	return &csi.CreateVolumeResponse{
 		Volume: &csi.Volume{
-			CapacityBytes: createDisk.MustDisk().MustProvisionedSize(),
-			VolumeId:      createDisk.MustDisk().MustId(),
+			CapacityBytes:      diskSize,
+			VolumeId:           newVolumeHandle(placement, disk.MustId()).String(),
+			VolumeContext:      volumeContext,
+			ContentSource:      nil,
+			AccessibleTopology: placement.topology,
+		},
+	}, nil
+}
+
+// createVolumeFromSnapshot restores the disk snapshot to a new disk and grows it to
+// the requested capacity. A restore in progress or finished is picked up again by
+// the disk name, wherever it was placed.
+func (c *ControllerService) createVolumeFromSnapshot(
+	ctx context.Context, conn *ovirtsdk.Connection, req *csi.CreateVolumeRequest, snapshotId string,
+	params *volumeParameters, requiredBytes int64) (*csi.CreateVolumeResponse, error) {
+
+	handle, err := parseSnapshotHandle(snapshotId)
+	if err != nil {
+		return nil, status.Errorf(codes.NotFound, "snapshot %s does not exist: %v", snapshotId, err)
+	}
+
+	// a snapshot still being taken is worth waiting for
+	if _, err := ovirt.WaitForSnapshot(ctx, conn, handle.vmId, handle.snapshotId); err != nil {
+		if _, ok := err.(*ovirtsdk.NotFoundError); ok {
+			return nil, status.Errorf(codes.NotFound, "snapshot %s does not exist", snapshotId)
+		}
+		return nil, err
+	}
+
+	snapshot, err := conn.SystemService().VmsService().VmService(handle.vmId).
+		SnapshotsService().
+		SnapshotService(handle.snapshotId).
+		Get().
+		Follow("disks").
+		Header(ovirt.CorrelationHeader(ctx)).
+		Send()
+	if err != nil {
+		if _, ok := err.(*ovirtsdk.NotFoundError); ok {
+			return nil, status.Errorf(codes.NotFound, "snapshot %s does not exist", snapshotId)
+		}
+		return nil, err
+	}
+	snapshottedDisk, ok := snapshotDisk(snapshot.MustSnapshot(), handle.diskId)
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "snapshot %s does not contain disk %s", snapshotId, handle.diskId)
+	}
+	if snapshotStatus, _ := snapshot.MustSnapshot().SnapshotStatus(); snapshotStatus != ovirtsdk.SNAPSHOTSTATUS_OK {
+		return nil, status.Errorf(codes.Unavailable, "snapshot %s is not ready, its status is %s", snapshotId, snapshotStatus)
+	}
+
+	snapshotSize := snapshottedDisk.MustProvisionedSize()
+	if requiredBytes < snapshotSize {
+		return nil, status.Errorf(codes.OutOfRange,
+			"requested capacity %d is smaller than the size %d of snapshot %s", requiredBytes, snapshotSize, snapshotId)
+	}
+
+	vmId, err := restoreVmId(ctx, conn, req.Name)
+	if err != nil {
+		return nil, err
+	}
+	var restoredDisk *ovirtsdk.Disk
+	if vmId == "" {
+		// a finished restore leaves only the disk behind
+		restoredDisk, err = diskByName(ctx, conn, req.Name)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if vmId == "" && restoredDisk == nil {
+		vmId, err = c.startRestore(ctx, conn, req, handle, params)
+		if err != nil {
+			klog.Errorf("Failed restoring snapshot %s to disk %s", snapshotId, req.Name)
+			return nil, err
+		}
+	} else {
+		klog.Infof("Restore of snapshot %s to disk %s is in progress or done already", snapshotId, req.Name)
+	}
+	if restoredDisk == nil {
+		restoredDisk, err = finishRestore(ctx, conn, handle, vmId, req.Name)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// an earlier call may have placed the disk elsewhere than this one would
+	placement, err := diskPlacement(ctx, conn, restoredDisk, req.AccessibilityRequirements)
+	if err != nil {
+		return nil, err
+	}
+	if placement == nil {
+		return nil, status.Errorf(codes.AlreadyExists,
+			"disk %s already exists outside of the accessibility requirements", req.Name)
+	}
+
+	restoredDisk, err = growDisk(ctx, conn, restoredDisk, requiredBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	volumeContext, err := diskVolumeContext(ctx, conn, restoredDisk, placement, params.diskInterface)
+	if err != nil {
+		return nil, err
+	}
+
+	return &csi.CreateVolumeResponse{
+		Volume: &csi.Volume{
+			CapacityBytes:      restoredDisk.MustProvisionedSize(),
+			VolumeId:           newVolumeHandle(placement, restoredDisk.MustId()).String(),
+			VolumeContext:      volumeContext,
+			ContentSource:      req.VolumeContentSource,
+			AccessibleTopology: placement.topology,
+		},
+	}, nil
+}
+
+// startRestore places the disk restored from the snapshot in the data center of
+// the snapshotted VM, where the temporary VM of the restore runs, and starts the
+// restore. It returns the ID of the temporary VM.
+func (c *ControllerService) startRestore(
+	ctx context.Context, conn *ovirtsdk.Connection, req *csi.CreateVolumeRequest, handle snapshotHandle,
+	params *volumeParameters) (string, error) {
+
+	cluster, err := vmCluster(ctx, conn, handle.vmId)
+	if err != nil {
+		return "", err
+	}
+	placement, err := c.placeNewVolume(ctx, conn, req, params, cluster.MustDataCenter().MustId())
+	if err != nil {
+		return "", err
+	}
+
+	diskBuilder := ovirtsdk.NewDiskBuilder().
+		Id(handle.diskId).
+		Name(req.Name).
+		Description(diskDescription).
+		StorageDomainsBuilderOfAny(*ovirtsdk.NewStorageDomainBuilder().Id(placement.storageDomain.MustId())).
+		Format(params.format).
+		Sparse(params.sparse).
+		Shareable(params.shareable)
+	if placement.diskProfile != nil {
+		diskBuilder.DiskProfile(placement.diskProfile)
+	}
+	if placement.quota != nil {
+		diskBuilder.Quota(placement.quota)
+	}
+	disk, err := diskBuilder.Build()
+	if err != nil {
+		// failed to construct the disk
+		return "", err
+	}
+
+	klog.Infof("Restoring snapshot %s to disk %s", handle, req.Name)
+	vmId, err := cloneSnapshot(ctx, conn, handle, cluster, req.Name, disk)
+	if err != nil {
+		return "", quotaError(ctx, conn, placement, err)
+	}
+	return vmId, nil
+}
+
+// createVolumeFromVolume copies the source disk to a storage domain of its data center and
+// waits for the copy to finish. A copy in progress or finished is picked up again by the disk
+// name, wherever it was placed.
+func (c *ControllerService) createVolumeFromVolume(
+	ctx context.Context, conn *ovirtsdk.Connection, req *csi.CreateVolumeRequest, sourceId string,
+	params *volumeParameters, requiredBytes int64) (*csi.CreateVolumeResponse, error) {
+
+	sourceHandle, err := parseVolumeHandle(sourceId)
+	if err != nil {
+		return nil, status.Errorf(codes.NotFound, "source disk %s does not exist: %v", sourceId, err)
+	}
+	diskService := conn.SystemService().DisksService().DiskService(sourceHandle.diskId)
+	sourceRequest := diskService.Get()
+	if sourceHandle.dataCenterId == "" {
+		// volumes of earlier versions of the driver do not tell their data center
+		sourceRequest.Follow("storage_domains")
+	}
+	source, err := sourceRequest.Header(ovirt.CorrelationHeader(ctx)).Send()
+	if err != nil {
+		if _, ok := err.(*ovirtsdk.NotFoundError); ok {
+			return nil, status.Errorf(codes.NotFound, "source disk %s does not exist", sourceId)
+		}
+		return nil, err
+	}
+
+	// a copy is shareable only when its source is
+	if sourceShareable, _ := source.MustDisk().Shareable(); params.shareable && !sourceShareable {
+		return nil, status.Errorf(codes.InvalidArgument,
+			"parameter %s: source disk %s is not shareable", ParameterShareable, sourceId)
+	}
+
+	sourceSize := source.MustDisk().MustProvisionedSize()
+	if requiredBytes < sourceSize {
+		return nil, status.Errorf(codes.OutOfRange,
+			"requested capacity %d is smaller than the size %d of source disk %s", requiredBytes, sourceSize, sourceId)
+	}
+
+	clone, err := diskByName(ctx, conn, req.Name)
+	if err != nil {
+		return nil, err
+	}
+	if clone == nil {
+		// oVirt copies disks only within their data center
+		dataCenterId := sourceHandle.dataCenterId
+		if dataCenterId == "" {
+			dataCenterId = diskVolumeHandle(source.MustDisk()).dataCenterId
+		}
+		placement, err := c.placeNewVolume(ctx, conn, req, params, dataCenterId)
+		if err != nil {
+			return nil, err
+		}
+
+		klog.Infof("Copying disk %s to disk %s", sourceId, req.Name)
+		copyRequest := diskService.Copy().
+			Disk(ovirtsdk.NewDiskBuilder().Name(req.Name).Description(diskDescription).MustBuild()).
+			StorageDomain(ovirtsdk.NewStorageDomainBuilder().Id(placement.storageDomain.MustId()).MustBuild())
+		if placement.diskProfile != nil {
+			copyRequest.DiskProfile(placement.diskProfile)
+		}
+		if placement.quota != nil {
+			copyRequest.Quota(placement.quota)
+		}
+		_, err = copyRequest.Header(ovirt.CorrelationHeader(ctx)).Send()
+		if err != nil {
+			klog.Errorf("Failed copying disk %s to disk %s", sourceId, req.Name)
+			return nil, quotaError(ctx, conn, placement, err)
+		}
+
+		clone, err = diskByName(ctx, conn, req.Name)
+		if err != nil {
+			return nil, err
+		}
+		if clone == nil {
+			return nil, status.Errorf(codes.Aborted, "copy of disk %s to disk %s is not visible yet", sourceId, req.Name)
+		}
+	}
+
+	// an earlier call may have placed the clone elsewhere than this one would
+	placement, err := diskPlacement(ctx, conn, clone, req.AccessibilityRequirements)
+	if err != nil {
+		return nil, err
+	}
+	if placement == nil {
+		return nil, status.Errorf(codes.AlreadyExists,
+			"disk %s already exists outside of the accessibility requirements", req.Name)
+	}
+
+	clone, err = ovirt.WaitForDisk(ctx, conn, clone.MustId())
+	if err != nil {
+		return nil, err
+	}
+
+	clone, err = growDisk(ctx, conn, clone, requiredBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	volumeContext, err := diskVolumeContext(ctx, conn, clone, placement, params.diskInterface)
+	if err != nil {
+		return nil, err
+	}
+
+	klog.Infof("Cloned disk %s to disk %s", sourceId, clone.MustId())
+	return &csi.CreateVolumeResponse{
+		Volume: &csi.Volume{
+			CapacityBytes:      clone.MustProvisionedSize(),
+			VolumeId:           newVolumeHandle(placement, clone.MustId()).String(),
+			VolumeContext:      volumeContext,
+			ContentSource:      req.VolumeContentSource,
+			AccessibleTopology: placement.topology,
 		},
 	}, nil
 }
@@ -104,17 +537,46 @@ func (c *ControllerService) DeleteVolume(ctx context.Context, req *csi.DeleteVol
 		return nil, err
 	}
 
-	diskService := conn.SystemService().DisksService().DiskService(req.VolumeId)
+	handle, err := parseVolumeHandle(req.VolumeId)
+	if err != nil {
+		klog.Infof("Volume %s was not created by the driver, returning OK: %v", req.VolumeId, err)
+		return &csi.DeleteVolumeResponse{}, nil
+	}
+	diskService := conn.SystemService().DisksService().DiskService(handle.diskId)
 
-	_, err = diskService.Get().Send()
-	// if doesn't exists we're done
+	_, err = diskService.Get().Header(ovirt.CorrelationHeader(ctx)).Send()
+	// if doesn't exists we're done, any other failure tells nothing about the disk
+	if _, ok := err.(*ovirtsdk.NotFoundError); ok {
+		klog.Infof("Disk %s does not exist, returning OK", handle.diskId)
+		return &csi.DeleteVolumeResponse{}, nil
+	}
 	if err != nil {
+		return nil, err
+	}
+
+	vms, err := vmsByDisk(ctx, conn, handle.diskId)
+	if err != nil {
+		return nil, err
+	}
+	for _, vm := range vms {
+		for _, attachment := range vm.MustDiskAttachments().Slice() {
+			if active, _ := attachment.Active(); active && attachment.MustDisk().MustId() == handle.diskId {
+				return nil, status.Errorf(codes.FailedPrecondition,
+					"disk %s is still attached to VM %s", handle.diskId, vm.MustName())
+			}
+		}
+	}
+
+	_, err = diskService.Remove().Header(ovirt.CorrelationHeader(ctx)).Send()
+	if _, ok := err.(*ovirtsdk.NotFoundError); ok {
 		return &csi.DeleteVolumeResponse{}, nil
 	}
-	_, err = diskService.Remove().Send()
 	if err != nil {
 		return nil, err
 	}
+	if err := ovirt.WaitForDiskRemoved(ctx, conn, handle.diskId); err != nil {
+		return nil, err
+	}
 
 	klog.Infof("Finished removing disk %s", req.VolumeId)
 	return &csi.DeleteVolumeResponse{}, nil
@@ -131,28 +593,121 @@ func (c *ControllerService) ControllerPublishVolume(
 		return nil, err
 	}
 
+	handle, err := parseVolumeHandle(req.VolumeId)
+	if err != nil {
+		return nil, status.Errorf(codes.NotFound, "volume %s does not exist: %v", req.VolumeId, err)
+	}
+
 	vmService := conn.SystemService().VmsService().VmService(req.NodeId)
+	if _, err := vmService.Get().Header(ovirt.CorrelationHeader(ctx)).Send(); err != nil {
+		if _, ok := err.(*ovirtsdk.NotFoundError); ok {
+			return nil, status.Errorf(codes.NotFound, "node %s does not exist", req.NodeId)
+		}
+		return nil, err
+	}
+	disk, err := conn.SystemService().DisksService().DiskService(handle.diskId).Get().Header(ovirt.CorrelationHeader(ctx)).Send()
+	if err != nil {
+		if _, ok := err.(*ovirtsdk.NotFoundError); ok {
+			return nil, status.Errorf(codes.NotFound, "disk %s does not exist", handle.diskId)
+		}
+		return nil, err
+	}
+	readOnly := req.Readonly || isReadOnlyAccessMode(req.VolumeCapability.GetAccessMode().GetMode())
+
+	// a retry finds the disk attached already
+	existing, err := existingAttachment(ctx, conn, req.NodeId, disk.MustDisk(), readOnly)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		if _, err := ovirt.WaitForDiskAttachment(ctx, conn, req.NodeId, existing.MustId()); err != nil {
+			return nil, err
+		}
+		klog.Infof("Disk %v is attached to VM %s already", req.VolumeId, req.NodeId)
+		return &csi.ControllerPublishVolumeResponse{}, nil
+	}
+
+	// volumes created before the interface was configurable have none in their context
+	diskInterface := ovirtsdk.DISKINTERFACE_VIRTIO_SCSI
+	if i, ok := req.VolumeContext[ParameterInterface]; ok {
+		diskInterface = ovirtsdk.DiskInterface(i)
+	}
 
 	attachmentBuilder := ovirtsdk.NewDiskAttachmentBuilder().
-		DiskBuilder(ovirtsdk.NewDiskBuilder().Id(req.VolumeId)).
-		Interface(ovirtsdk.DISKINTERFACE_VIRTIO_SCSI).
+		DiskBuilder(ovirtsdk.NewDiskBuilder().Id(handle.diskId)).
+		Interface(diskInterface).
 		Bootable(false).
-		Active(true)
+		Active(true).
+		ReadOnly(readOnly)
 
-	_, err = vmService.
+	attachment, err := vmService.
 		DiskAttachmentsService().
 		Add().
 		Attachment(attachmentBuilder.MustBuild()).
+		Header(ovirt.CorrelationHeader(ctx)).
 		Send()
 	if err != nil {
 		return nil, err
 	}
+	// the node looks for the device as soon as this returns
+	if _, err := ovirt.WaitForDiskAttachment(ctx, conn, req.NodeId, attachment.MustAttachment().MustId()); err != nil {
+		return nil, err
+	}
 	klog.Infof("Attached Disk %v to VM %s", req.VolumeId, req.NodeId)
 	return &csi.ControllerPublishVolumeResponse{}, nil
 }
 
+// existingAttachment returns the attachment of the disk to the VM, activating
+// it when it is inactive, or nil when the disk is not attached to the VM. A
+// disk that is not shareable and attached to another VM is a FailedPrecondition.
+func existingAttachment(
+	ctx context.Context, conn *ovirtsdk.Connection, vmId string, disk *ovirtsdk.Disk, readOnly bool) (*ovirtsdk.DiskAttachment, error) {
+
+	vms, err := vmsByDisk(ctx, conn, disk.MustId())
+	if err != nil {
+		return nil, err
+	}
+
+	var existing *ovirtsdk.DiskAttachment
+	for _, vm := range vms {
+		for _, attachment := range vm.MustDiskAttachments().Slice() {
+			if attachment.MustDisk().MustId() != disk.MustId() {
+				continue
+			}
+			if vm.MustId() == vmId {
+				existing = attachment
+			} else if shareable, _ := disk.Shareable(); !shareable {
+				return nil, status.Errorf(codes.FailedPrecondition,
+					"disk %s is attached to VM %s", disk.MustId(), vm.MustName())
+			}
+		}
+	}
+	if existing == nil {
+		return nil, nil
+	}
+
+	if attachedReadOnly, _ := existing.ReadOnly(); attachedReadOnly != readOnly {
+		return nil, status.Errorf(codes.AlreadyExists,
+			"disk %s is attached to VM %s with read only %t", disk.MustId(), vmId, attachedReadOnly)
+	}
+	if active, _ := existing.Active(); !active {
+		klog.Infof("Activating the attachment of disk %s to VM %s", disk.MustId(), vmId)
+		_, err := conn.SystemService().VmsService().VmService(vmId).
+			DiskAttachmentsService().
+			AttachmentService(existing.MustId()).
+			Update().
+			DiskAttachment(ovirtsdk.NewDiskAttachmentBuilder().Active(true).MustBuild()).
+			Header(ovirt.CorrelationHeader(ctx)).
+			Send()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return existing, nil
+}
+
 //ControllerUnpublishVolume detaches the disk from the VM.
-func (c *ControllerService) ControllerUnpublishVolume(_ context.Context, req *csi.ControllerUnpublishVolumeRequest) (*csi.ControllerUnpublishVolumeResponse, error) {
+func (c *ControllerService) ControllerUnpublishVolume(ctx context.Context, req *csi.ControllerUnpublishVolumeRequest) (*csi.ControllerUnpublishVolumeResponse, error) {
 	klog.Infof("Detaching Disk %s from VM %s", req.VolumeId, req.NodeId)
 	conn, err := c.ovirtClient.GetConnection()
 	if err != nil {
@@ -160,55 +715,459 @@ func (c *ControllerService) ControllerUnpublishVolume(_ context.Context, req *cs
 		return nil, err
 	}
 
-	attachment, err := diskAttachmentByVmAndDisk(conn, req.NodeId, req.VolumeId)
+	handle, err := parseVolumeHandle(req.VolumeId)
 	if err != nil {
-		klog.Errorf("Failed to get disk attachment %s for VM %s, returning OK", req.VolumeId, req.NodeId)
+		klog.Infof("Volume %s was not created by the driver, returning OK: %v", req.VolumeId, err)
+		return &csi.ControllerUnpublishVolumeResponse{}, nil
+	}
+
+	attachment, err := diskAttachmentByVmAndDisk(ctx, conn, req.NodeId, handle.diskId)
+	// a disk which is not attached, or a VM which is gone, is detached already,
+	// any other failure tells nothing about the attachment
+	if _, ok := err.(*ovirtsdk.NotFoundError); ok || status.Code(err) == codes.NotFound {
+		klog.Infof("Disk %s is not attached to VM %s, returning OK", req.VolumeId, req.NodeId)
 		return &csi.ControllerUnpublishVolumeResponse{}, nil
 	}
+	if err != nil {
+		klog.Errorf("Failed to get disk attachment %s for VM %s", req.VolumeId, req.NodeId)
+		return nil, err
+	}
 	_, err = conn.SystemService().VmsService().VmService(req.NodeId).
 		DiskAttachmentsService().
 		AttachmentService(attachment.MustId()).
 		Remove().
+		Header(ovirt.CorrelationHeader(ctx)).
 		Send()
 
 	if err != nil {
 		return nil, err
 	}
+	if err := ovirt.WaitForDiskAttachmentRemoved(ctx, conn, req.NodeId, attachment.MustId()); err != nil {
+		return nil, err
+	}
 	return &csi.ControllerUnpublishVolumeResponse{}, nil
 }
 
-//ValidateVolumeCapabilities
-func (c *ControllerService) ValidateVolumeCapabilities(context.Context, *csi.ValidateVolumeCapabilitiesRequest) (*csi.ValidateVolumeCapabilitiesResponse, error) {
-	return nil, status.Error(codes.Unimplemented, "")
+//ValidateVolumeCapabilities checks the capabilities against the shareable and read only flags of the disk
+func (c *ControllerService) ValidateVolumeCapabilities(ctx context.Context, req *csi.ValidateVolumeCapabilitiesRequest) (*csi.ValidateVolumeCapabilitiesResponse, error) {
+	if req.VolumeId == "" {
+		return nil, status.Error(codes.InvalidArgument, "volume id is required")
+	}
+	if len(req.VolumeCapabilities) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "volume capabilities are required")
+	}
+
+	conn, err := c.ovirtClient.GetConnection()
+	if err != nil {
+		klog.Errorf("Failed to get ovirt client connection")
+		return nil, err
+	}
+
+	handle, err := parseVolumeHandle(req.VolumeId)
+	if err != nil {
+		return nil, status.Errorf(codes.NotFound, "volume %s does not exist: %v", req.VolumeId, err)
+	}
+	disk, err := conn.SystemService().DisksService().DiskService(handle.diskId).Get().Header(ovirt.CorrelationHeader(ctx)).Send()
+	if err != nil {
+		if _, ok := err.(*ovirtsdk.NotFoundError); ok {
+			return nil, status.Errorf(codes.NotFound, "disk %s does not exist", req.VolumeId)
+		}
+		return nil, err
+	}
+
+	for _, capability := range req.VolumeCapabilities {
+		if message := unsupportedCapability(disk.MustDisk(), capability); message != "" {
+			return &csi.ValidateVolumeCapabilitiesResponse{Message: message}, nil
+		}
+	}
+
+	return &csi.ValidateVolumeCapabilitiesResponse{
+		Confirmed: &csi.ValidateVolumeCapabilitiesResponse_Confirmed{
+			VolumeContext:      req.VolumeContext,
+			VolumeCapabilities: req.VolumeCapabilities,
+			Parameters:         req.Parameters,
+		},
+	}, nil
 }
 
-//ListVolumes
-func (c *ControllerService) ListVolumes(context.Context, *csi.ListVolumesRequest) (*csi.ListVolumesResponse, error) {
-	return nil, status.Error(codes.Unimplemented, "")
+//ListVolumes lists the disks created by the driver along with the VMs they are attached to
+func (c *ControllerService) ListVolumes(ctx context.Context, req *csi.ListVolumesRequest) (*csi.ListVolumesResponse, error) {
+	conn, err := c.ovirtClient.GetConnection()
+	if err != nil {
+		klog.Errorf("Failed to get ovirt client connection")
+		return nil, err
+	}
+
+	disks, err := driverDisks(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+
+	// a stable order keeps the starting tokens valid between calls
+	sort.Slice(disks, func(i, j int) bool {
+		return disks[i].MustId() < disks[j].MustId()
+	})
+
+	start, end, nextToken, err := paginate(req.StartingToken, req.MaxEntries, len(disks))
+	if err != nil {
+		return nil, err
+	}
+
+	vmIds, err := vmIdsByDisk(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]*csi.ListVolumesResponse_Entry, 0, end-start)
+	for _, disk := range disks[start:end] {
+		entries = append(entries, &csi.ListVolumesResponse_Entry{
+			Volume: &csi.Volume{
+				CapacityBytes: disk.MustProvisionedSize(),
+				VolumeId:      diskVolumeHandle(disk).String(),
+			},
+			Status: &csi.ListVolumesResponse_VolumeStatus{
+				PublishedNodeIds: vmIds[disk.MustId()],
+			},
+		})
+	}
+	return &csi.ListVolumesResponse{Entries: entries, NextToken: nextToken}, nil
 }
 
-//GetCapacity
-func (c *ControllerService) GetCapacity(context.Context, *csi.GetCapacityRequest) (*csi.GetCapacityResponse, error) {
-	return nil, status.Error(codes.Unimplemented, "")
+//GetCapacity sums the space left on the storage domain of the parameters, or on every active data domain
+//the selection policy may pick when none is named, within the data centers of the accessible topology
+func (c *ControllerService) GetCapacity(ctx context.Context, req *csi.GetCapacityRequest) (*csi.GetCapacityResponse, error) {
+	params, err := parseVolumeParameters(req.Parameters)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := c.ovirtClient.GetConnection()
+	if err != nil {
+		klog.Errorf("Failed to get ovirt client connection")
+		return nil, err
+	}
+
+	dataCenters, err := dataCentersOfSegments(ctx, conn, req.AccessibleTopology.GetSegments())
+	if err != nil {
+		return nil, err
+	}
+
+	var capacity int64
+	for _, dataCenter := range dataCenters {
+		storageDomains, err := activeDataDomains(ctx, conn, dataCenter.MustId())
+		if err != nil {
+			return nil, err
+		}
+		for _, sd := range storageDomains {
+			// only the storage domains CreateVolume may pick count
+			if !storageDomainAllowed(sd, params) {
+				continue
+			}
+			capacity += storageDomainCapacity(sd, params.overcommitRatio)
+		}
+	}
+
+	return &csi.GetCapacityResponse{AvailableCapacity: capacity}, nil
 }
 
-//CreateSnapshot
-func (c *ControllerService) CreateSnapshot(context.Context, *csi.CreateSnapshotRequest) (*csi.CreateSnapshotResponse, error) {
-	return nil, status.Error(codes.Unimplemented, "")
+//CreateSnapshot snapshots the disk through the VM it is attached to. The VM snapshot
+//holds only this disk and its description is the snapshot name.
+func (c *ControllerService) CreateSnapshot(ctx context.Context, req *csi.CreateSnapshotRequest) (*csi.CreateSnapshotResponse, error) {
+	if req.Name == "" {
+		return nil, status.Error(codes.InvalidArgument, "snapshot name is required")
+	}
+	if req.SourceVolumeId == "" {
+		return nil, status.Error(codes.InvalidArgument, "source volume id is required")
+	}
+
+	klog.Infof("Creating snapshot %s of disk %s", req.Name, req.SourceVolumeId)
+	conn, err := c.ovirtClient.GetConnection()
+	if err != nil {
+		klog.Errorf("Failed to get ovirt client connection")
+		return nil, err
+	}
+
+	source, err := parseVolumeHandle(req.SourceVolumeId)
+	if err != nil {
+		return nil, status.Errorf(codes.NotFound, "volume %s does not exist: %v", req.SourceVolumeId, err)
+	}
+
+	disk, err := conn.SystemService().DisksService().DiskService(source.diskId).Get().Header(ovirt.CorrelationHeader(ctx)).Send()
+	if err != nil {
+		if _, ok := err.(*ovirtsdk.NotFoundError); ok {
+			return nil, status.Errorf(codes.NotFound, "disk %s does not exist", req.SourceVolumeId)
+		}
+		return nil, err
+	}
+
+	vms, err := vmsByDisk(ctx, conn, source.diskId)
+	if err != nil {
+		return nil, err
+	}
+	if len(vms) == 0 {
+		return nil, status.Errorf(codes.FailedPrecondition,
+			"disk %s is not attached to any VM, oVirt can only snapshot attached disks", req.SourceVolumeId)
+	}
+
+	// idempotence first - see if the snapshot already exists by its description, on any VM
+	// the disk is attached to, as it may be shared or have moved since
+	var snapshot *ovirtsdk.Snapshot
+	vmId := vms[0].MustId()
+	for _, vm := range vms {
+		snapshot, err = snapshotByDescription(ctx, conn, vm.MustId(), req.Name)
+		if err != nil {
+			return nil, err
+		}
+		if snapshot != nil {
+			vmId = vm.MustId()
+			break
+		}
+	}
+	if snapshot != nil {
+		snapshottedDisk, ok := snapshotDisk(snapshot, source.diskId)
+		if !ok {
+			return nil, status.Errorf(codes.AlreadyExists,
+				"snapshot %s already exists but does not contain disk %s", req.Name, req.SourceVolumeId)
+		}
+		handle := snapshotHandle{vmId: vmId, snapshotId: snapshot.MustId(), diskId: source.diskId}
+		s, err := csiSnapshot(handle, req.SourceVolumeId, snapshot, snapshottedDisk.MustProvisionedSize())
+		if err != nil {
+			return nil, err
+		}
+		return &csi.CreateSnapshotResponse{Snapshot: s}, nil
+	}
+
+	snapshot, err = ovirtsdk.NewSnapshotBuilder().
+		Description(req.Name).
+		PersistMemorystate(false).
+		DiskAttachmentsOfAny(
+			ovirtsdk.NewDiskAttachmentBuilder().
+				DiskBuilder(ovirtsdk.NewDiskBuilder().Id(source.diskId)).
+				MustBuild()).
+		Build()
+	if err != nil {
+		// failed to construct the snapshot
+		return nil, err
+	}
+
+	createSnapshot, err := conn.SystemService().VmsService().VmService(vmId).
+		SnapshotsService().
+		Add().
+		Snapshot(snapshot).
+		Header(ovirt.CorrelationHeader(ctx)).
+		Send()
+	if err != nil {
+		klog.Errorf("Failed creating snapshot %s of disk %s", req.Name, req.SourceVolumeId)
+		return nil, err
+	}
+
+	handle := snapshotHandle{vmId: vmId, snapshotId: createSnapshot.MustSnapshot().MustId(), diskId: source.diskId}
+	s, err := csiSnapshot(handle, req.SourceVolumeId, createSnapshot.MustSnapshot(), disk.MustDisk().MustProvisionedSize())
+	if err != nil {
+		return nil, err
+	}
+	klog.Infof("Created snapshot %s of disk %s", handle, req.SourceVolumeId)
+	return &csi.CreateSnapshotResponse{Snapshot: s}, nil
 }
 
-//DeleteSnapshot
-func (c *ControllerService) DeleteSnapshot(context.Context, *csi.DeleteSnapshotRequest) (*csi.DeleteSnapshotResponse, error) {
-	return nil, status.Error(codes.Unimplemented, "")
+//DeleteSnapshot removes the VM snapshot holding the disk snapshot
+func (c *ControllerService) DeleteSnapshot(ctx context.Context, req *csi.DeleteSnapshotRequest) (*csi.DeleteSnapshotResponse, error) {
+	if req.SnapshotId == "" {
+		return nil, status.Error(codes.InvalidArgument, "snapshot id is required")
+	}
+
+	klog.Infof("Removing snapshot %s", req.SnapshotId)
+	handle, err := parseSnapshotHandle(req.SnapshotId)
+	if err != nil {
+		// not one of ours, so there is nothing to remove
+		klog.Infof("Snapshot %s is unknown, returning OK: %v", req.SnapshotId, err)
+		return &csi.DeleteSnapshotResponse{}, nil
+	}
+
+	conn, err := c.ovirtClient.GetConnection()
+	if err != nil {
+		klog.Errorf("Failed to get ovirt client connection")
+		return nil, err
+	}
+
+	_, err = conn.SystemService().VmsService().VmService(handle.vmId).
+		SnapshotsService().
+		SnapshotService(handle.snapshotId).
+		Remove().
+		Header(ovirt.CorrelationHeader(ctx)).
+		Send()
+	if err != nil {
+		if _, ok := err.(*ovirtsdk.NotFoundError); ok {
+			return &csi.DeleteSnapshotResponse{}, nil
+		}
+		return nil, err
+	}
+
+	klog.Infof("Finished removing snapshot %s", req.SnapshotId)
+	return &csi.DeleteSnapshotResponse{}, nil
 }
 
-//ListSnapshots
-func (c *ControllerService) ListSnapshots(context.Context, *csi.ListSnapshotsRequest) (*csi.ListSnapshotsResponse, error) {
-	return nil, status.Error(codes.Unimplemented, "")
+//ListSnapshots lists the disk snapshots, by snapshot id, by source disk or those of all the disks of the driver
+func (c *ControllerService) ListSnapshots(ctx context.Context, req *csi.ListSnapshotsRequest) (*csi.ListSnapshotsResponse, error) {
+	conn, err := c.ovirtClient.GetConnection()
+	if err != nil {
+		klog.Errorf("Failed to get ovirt client connection")
+		return nil, err
+	}
+
+	var snapshots []*csi.Snapshot
+	switch {
+	case req.SnapshotId != "":
+		handle, err := parseSnapshotHandle(req.SnapshotId)
+		if err != nil {
+			return &csi.ListSnapshotsResponse{}, nil
+		}
+		snapshot, err := conn.SystemService().VmsService().VmService(handle.vmId).
+			SnapshotsService().
+			SnapshotService(handle.snapshotId).
+			Get().
+			Follow("disks").
+			Header(ovirt.CorrelationHeader(ctx)).
+			Send()
+		if err != nil {
+			if _, ok := err.(*ovirtsdk.NotFoundError); ok {
+				return &csi.ListSnapshotsResponse{}, nil
+			}
+			return nil, err
+		}
+		sourceIds, err := driverVolumeIds(ctx, conn)
+		if err != nil {
+			return nil, err
+		}
+		snapshots, err = csiSnapshots(handle.vmId, []*ovirtsdk.Snapshot{snapshot.MustSnapshot()}, handle.diskId, sourceIds)
+		if err != nil {
+			return nil, err
+		}
+	case req.SourceVolumeId != "":
+		source, err := parseVolumeHandle(req.SourceVolumeId)
+		if err != nil {
+			return &csi.ListSnapshotsResponse{}, nil
+		}
+		vms, err := vmsByDisk(ctx, conn, source.diskId)
+		if err != nil {
+			return nil, err
+		}
+		for _, vm := range vms {
+			vmSnapshotList, err := vmSnapshots(ctx, conn, vm.MustId())
+			if err != nil {
+				return nil, err
+			}
+			// the source is reported in the form the caller knows it
+			s, err := csiSnapshots(vm.MustId(), vmSnapshotList, source.diskId, map[string]string{source.diskId: req.SourceVolumeId})
+			if err != nil {
+				return nil, err
+			}
+			snapshots = append(snapshots, s...)
+		}
+	default:
+		sourceIds, err := driverVolumeIds(ctx, conn)
+		if err != nil {
+			return nil, err
+		}
+		vms, err := conn.SystemService().VmsService().List().Header(ovirt.CorrelationHeader(ctx)).Send()
+		if err != nil {
+			return nil, err
+		}
+		for _, vm := range vms.MustVms().Slice() {
+			vmSnapshotList, err := vmSnapshots(ctx, conn, vm.MustId())
+			if err != nil {
+				return nil, err
+			}
+			s, err := csiSnapshots(vm.MustId(), vmSnapshotList, "", sourceIds)
+			if err != nil {
+				return nil, err
+			}
+			snapshots = append(snapshots, s...)
+		}
+	}
+
+	// a stable order keeps the starting tokens valid between calls
+	sort.Slice(snapshots, func(i, j int) bool {
+		return snapshots[i].SnapshotId < snapshots[j].SnapshotId
+	})
+
+	start, end, nextToken, err := paginate(req.StartingToken, req.MaxEntries, len(snapshots))
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]*csi.ListSnapshotsResponse_Entry, 0, end-start)
+	for _, s := range snapshots[start:end] {
+		entries = append(entries, &csi.ListSnapshotsResponse_Entry{Snapshot: s})
+	}
+	return &csi.ListSnapshotsResponse{Entries: entries, NextToken: nextToken}, nil
+}
+
+//ControllerExpandVolume grows the disk, through its attachment if it is attached to a VM
+func (c *ControllerService) ControllerExpandVolume(ctx context.Context, req *csi.ControllerExpandVolumeRequest) (*csi.ControllerExpandVolumeResponse, error) {
+	requiredBytes := req.CapacityRange.GetRequiredBytes()
+	if requiredBytes <= 0 {
+		return nil, status.Error(codes.InvalidArgument, "required capacity must be set")
+	}
+
+	klog.Infof("Expanding disk %s to %d", req.VolumeId, requiredBytes)
+	conn, err := c.ovirtClient.GetConnection()
+	if err != nil {
+		klog.Errorf("Failed to get ovirt client connection")
+		return nil, err
+	}
+
+	handle, err := parseVolumeHandle(req.VolumeId)
+	if err != nil {
+		return nil, status.Errorf(codes.NotFound, "volume %s does not exist: %v", req.VolumeId, err)
+	}
+
+	disk, err := conn.SystemService().DisksService().DiskService(handle.diskId).Get().Header(ovirt.CorrelationHeader(ctx)).Send()
+	if err != nil {
+		if _, ok := err.(*ovirtsdk.NotFoundError); ok {
+			return nil, status.Errorf(codes.NotFound, "disk %s does not exist", req.VolumeId)
+		}
+		return nil, err
+	}
+
+	// a block volume has no filesystem to grow on the node
+	nodeExpansionRequired := req.VolumeCapability.GetBlock() == nil
+
+	if disk.MustDisk().MustProvisionedSize() >= requiredBytes {
+		klog.Infof("Disk %s is already %d, no expansion needed", req.VolumeId, disk.MustDisk().MustProvisionedSize())
+		return &csi.ControllerExpandVolumeResponse{
+			CapacityBytes:         disk.MustDisk().MustProvisionedSize(),
+			NodeExpansionRequired: nodeExpansionRequired,
+		}, nil
+	}
+
+	vms, err := vmsByDisk(ctx, conn, handle.diskId)
+	if err != nil {
+		return nil, err
+	}
+	if len(vms) == 0 {
+		err = extendDisk(ctx, conn, handle.diskId, requiredBytes)
+	} else {
+		err = extendAttachedDisk(ctx, conn, vms[0].MustId(), handle.diskId, requiredBytes)
+	}
+	if err != nil {
+		klog.Errorf("Failed expanding disk %s to %d", req.VolumeId, requiredBytes)
+		return nil, err
+	}
+
+	if _, err := ovirt.WaitForDisk(ctx, conn, handle.diskId); err != nil {
+		return nil, err
+	}
+
+	klog.Infof("Expanded disk %s to %d", req.VolumeId, requiredBytes)
+	return &csi.ControllerExpandVolumeResponse{
+		CapacityBytes:         requiredBytes,
+		NodeExpansionRequired: nodeExpansionRequired,
+	}, nil
 }
 
-//ControllerExpandVolume
-func (c *ControllerService) ControllerExpandVolume(context.Context, *csi.ControllerExpandVolumeRequest) (*csi.ControllerExpandVolumeResponse, error) {
+//ControllerGetVolume is not supported, the driver does not report the GET_VOLUME controller capability
+func (c *ControllerService) ControllerGetVolume(context.Context, *csi.ControllerGetVolumeRequest) (*csi.ControllerGetVolumeResponse, error) {
 	return nil, status.Error(codes.Unimplemented, "")
 }
 
@@ -0,0 +1,203 @@
+package service
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/ovirt/csi-driver/internal/ovirt"
+	ovirtsdk "github.com/ovirt/go-ovirt"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog"
+)
+
+// DiskProfileAnnotation on a persistent volume of the driver moves its disk to
+// the disk profile of that name on the storage domain of the disk. CSI has no
+// call to modify a volume, so changing the profile of a StorageClass does not
+// reach the existing volumes otherwise.
+const DiskProfileAnnotation = "csi.ovirt.org/disk-profile"
+
+// diskProfileSyncInterval is how often the annotated persistent volumes are checked
+const diskProfileSyncInterval = time.Minute
+
+// The volume context keys of the disk profile and its QoS limits
+const (
+	volumeContextDiskProfile        = "diskProfile"
+	volumeContextQos                = "qos"
+	volumeContextMaxIops            = "qosMaxIops"
+	volumeContextMaxReadIops        = "qosMaxReadIops"
+	volumeContextMaxWriteIops       = "qosMaxWriteIops"
+	volumeContextMaxThroughput      = "qosMaxThroughput"
+	volumeContextMaxReadThroughput  = "qosMaxReadThroughput"
+	volumeContextMaxWriteThroughput = "qosMaxWriteThroughput"
+)
+
+// storageDomainDiskProfile finds the disk profile with the id, or else with the
+// name, among the profiles of the storage domain. It returns nil when there is none.
+func storageDomainDiskProfile(ctx context.Context, connection *ovirtsdk.Connection, sdId string, name string, id string) (*ovirtsdk.DiskProfile, error) {
+	profiles, err := connection.SystemService().StorageDomainsService().StorageDomainService(sdId).
+		DiskProfilesService().
+		List().
+		Header(ovirt.CorrelationHeader(ctx)).
+		Send()
+	if err != nil {
+		return nil, err
+	}
+	for _, profile := range profiles.MustProfiles().Slice() {
+		if (id != "" && profile.MustId() == id) || (id == "" && profile.MustName() == name) {
+			return profile, nil
+		}
+	}
+	return nil, nil
+}
+
+// resolveDiskProfile finds the disk profile of the parameters on the storage
+// domain the volume is placed on. It returns nil when the parameters name none.
+func resolveDiskProfile(ctx context.Context, connection *ovirtsdk.Connection, placement *volumePlacement, p *volumeParameters) (*ovirtsdk.DiskProfile, error) {
+	if p.diskProfileName == "" && p.diskProfileId == "" {
+		return nil, nil
+	}
+	profile, err := storageDomainDiskProfile(ctx, connection, placement.storageDomain.MustId(), p.diskProfileName, p.diskProfileId)
+	if err != nil {
+		return nil, err
+	}
+	if profile != nil {
+		return profile, nil
+	}
+	if p.diskProfileId != "" {
+		return nil, status.Errorf(codes.InvalidArgument,
+			"parameter %s: disk profile %s does not exist on storage domain %s", ParameterDiskProfileId, p.diskProfileId, placement.storageDomain.MustName())
+	}
+	return nil, status.Errorf(codes.InvalidArgument,
+		"parameter %s: disk profile %s does not exist on storage domain %s", ParameterDiskProfileName, p.diskProfileName, placement.storageDomain.MustName())
+}
+
+// diskProfileOf returns the disk profile the disk on the storage domain has, or nil when it has none
+func diskProfileOf(ctx context.Context, connection *ovirtsdk.Connection, sdId string, disk *ovirtsdk.Disk) (*ovirtsdk.DiskProfile, error) {
+	profile, ok := disk.DiskProfile()
+	if !ok {
+		return nil, nil
+	}
+	return storageDomainDiskProfile(ctx, connection, sdId, "", profile.MustId())
+}
+
+// addDiskProfileContext records the disk profile and the limits of its QoS in
+// the volume context. The QoS belongs to the data center of the volume.
+func addDiskProfileContext(
+	ctx context.Context, connection *ovirtsdk.Connection, volumeContext map[string]string, placement *volumePlacement, profile *ovirtsdk.DiskProfile) error {
+
+	if profile == nil {
+		return nil
+	}
+	volumeContext[volumeContextDiskProfile] = profile.MustName()
+
+	qosLink, ok := profile.Qos()
+	if !ok {
+		return nil
+	}
+	response, err := connection.SystemService().DataCentersService().DataCenterService(placement.dataCenter.MustId()).
+		QossService().
+		QosService(qosLink.MustId()).
+		Get().
+		Header(ovirt.CorrelationHeader(ctx)).
+		Send()
+	if err != nil {
+		return err
+	}
+	qos := response.MustQos()
+	volumeContext[volumeContextQos] = qos.MustName()
+	for key, limit := range map[string]func() (int64, bool){
+		volumeContextMaxIops:            qos.MaxIops,
+		volumeContextMaxReadIops:        qos.MaxReadIops,
+		volumeContextMaxWriteIops:       qos.MaxWriteIops,
+		volumeContextMaxThroughput:      qos.MaxThroughput,
+		volumeContextMaxReadThroughput:  qos.MaxReadThroughput,
+		volumeContextMaxWriteThroughput: qos.MaxWriteThroughput,
+	} {
+		if value, ok := limit(); ok && value > 0 {
+			volumeContext[key] = strconv.FormatInt(value, 10)
+		}
+	}
+	return nil
+}
+
+// SyncDiskProfiles moves the disks of the annotated persistent volumes to
+// their disk profile until the stop channel is closed.
+func (c *ControllerService) SyncDiskProfiles(stop <-chan struct{}) {
+	wait.Until(func() {
+		if err := c.syncDiskProfiles(); err != nil {
+			klog.Errorf("Failed to sync disk profiles: %v", err)
+		}
+	}, diskProfileSyncInterval, stop)
+}
+
+func (c *ControllerService) syncDiskProfiles() error {
+	var volumes corev1.PersistentVolumeList
+	if err := c.client.List(context.Background(), &volumes); err != nil {
+		return err
+	}
+
+	conn, err := c.ovirtClient.GetConnection()
+	if err != nil {
+		return err
+	}
+
+	for _, pv := range volumes.Items {
+		name, ok := pv.Annotations[DiskProfileAnnotation]
+		if !ok || pv.Spec.CSI == nil || pv.Spec.CSI.Driver != VendorName {
+			continue
+		}
+		handle, err := parseVolumeHandle(pv.Spec.CSI.VolumeHandle)
+		if err != nil {
+			klog.Errorf("Failed to move volume %s to disk profile %s: %v", pv.Name, name, err)
+			continue
+		}
+		ctx := ovirt.WithCorrelationId(context.Background(), ovirt.CorrelationId(handle.diskId, "profile"))
+		if err := moveDiskToProfile(ctx, conn, handle, name); err != nil {
+			// one bad volume should not hold back the others
+			klog.Errorf("Failed to move the disk of volume %s to disk profile %s: %v", pv.Name, name, err)
+		}
+	}
+	return nil
+}
+
+// moveDiskToProfile sets the disk profile of that name on the disk of the volume, unless it
+// already has it. The profile is looked up on the storage domain of the volume handle, which
+// volumes created by earlier versions of the driver lack, so theirs is taken from the disk.
+func moveDiskToProfile(ctx context.Context, connection *ovirtsdk.Connection, handle volumeHandle, profileName string) error {
+	diskId := handle.diskId
+	diskService := connection.SystemService().DisksService().DiskService(diskId)
+	disk, err := diskService.Get().Header(ovirt.CorrelationHeader(ctx)).Send()
+	if err != nil {
+		return err
+	}
+
+	sdId := handle.storageDomainId
+	if sdId == "" {
+		storageDomains, ok := disk.MustDisk().StorageDomains()
+		if !ok || len(storageDomains.Slice()) == 0 {
+			return status.Errorf(codes.FailedPrecondition, "disk %s has no storage domain to take a disk profile from", diskId)
+		}
+		sdId = storageDomains.Slice()[0].MustId()
+	}
+	profile, err := storageDomainDiskProfile(ctx, connection, sdId, profileName, "")
+	if err != nil {
+		return err
+	}
+	if profile == nil {
+		return status.Errorf(codes.NotFound, "disk profile %s does not exist on storage domain %s", profileName, sdId)
+	}
+	if current, ok := disk.MustDisk().DiskProfile(); ok && current.MustId() == profile.MustId() {
+		return nil
+	}
+
+	klog.Infof("Moving disk %s to disk profile %s", diskId, profileName)
+	_, err = diskService.Update().
+		Disk(ovirtsdk.NewDiskBuilder().DiskProfile(profile).MustBuild()).
+		Header(ovirt.CorrelationHeader(ctx)).
+		Send()
+	return err
+}
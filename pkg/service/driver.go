@@ -12,6 +12,13 @@ var (
 	VendorName    = "csi.ovirt.org"
 )
 
+const (
+	// TopologyKeyDataCenter is the topology segment of the oVirt data center of a node or a volume
+	TopologyKeyDataCenter = "topology.csi.ovirt.org/datacenter"
+	// TopologyKeyCluster is the topology segment of the oVirt cluster of a node
+	TopologyKeyCluster = "topology.csi.ovirt.org/cluster"
+)
+
 type OvirtCSIDriver struct {
 	*IdentityService
 	*ControllerService
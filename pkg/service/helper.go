@@ -2,13 +2,66 @@ package service
 
 import (
 	"fmt"
+	"strconv"
 
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/ovirt/csi-driver/internal/ovirt"
 	ovirtsdk "github.com/ovirt/go-ovirt"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog"
 )
 
-func diskAttachmentByVmAndDisk(connection *ovirtsdk.Connection, vmId string, diskId string) (*ovirtsdk.DiskAttachment, error) {
+// isReadOnlyAccessMode tells whether the access mode only allows reading the volume
+func isReadOnlyAccessMode(mode csi.VolumeCapability_AccessMode_Mode) bool {
+	return mode == csi.VolumeCapability_AccessMode_SINGLE_NODE_READER_ONLY ||
+		mode == csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY
+}
+
+// isMultiNodeAccessMode tells whether the access mode lets several nodes use the volume,
+// which in oVirt takes a shareable disk
+func isMultiNodeAccessMode(mode csi.VolumeCapability_AccessMode_Mode) bool {
+	return mode == csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY ||
+		mode == csi.VolumeCapability_AccessMode_MULTI_NODE_SINGLE_WRITER ||
+		mode == csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER
+}
+
+// clusteredFsTypes are the filesystems which several nodes may mount for writing at once
+var clusteredFsTypes = map[string]bool{"gfs2": true, "ocfs2": true}
+
+// unsupportedCapability tells why the disk does not support the volume capability,
+// or returns an empty string if it does
+func unsupportedCapability(disk *ovirtsdk.Disk, capability *csi.VolumeCapability) string {
+	mode := capability.GetAccessMode().GetMode()
+	if mode == csi.VolumeCapability_AccessMode_UNKNOWN {
+		return "access mode is required"
+	}
+	if capability.GetBlock() == nil && capability.GetMount() == nil {
+		return "access type must be block or mount"
+	}
+
+	shareable, _ := disk.Shareable()
+	if isMultiNodeAccessMode(mode) && !shareable {
+		return fmt.Sprintf("disk %s is not shareable, access mode %s is unsupported", disk.MustId(), mode)
+	}
+
+	readOnly, _ := disk.ReadOnly()
+	if readOnly && !isReadOnlyAccessMode(mode) {
+		return fmt.Sprintf("disk %s is read only, access mode %s is unsupported", disk.MustId(), mode)
+	}
+
+	if mount := capability.GetMount(); mount != nil &&
+		mode == csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER && !clusteredFsTypes[mount.FsType] {
+		return fmt.Sprintf("filesystem %q can not be written from several nodes, use a block volume or gfs2 or ocfs2", mount.FsType)
+	}
+
+	return ""
+}
+
+func diskAttachmentByVmAndDisk(ctx context.Context, connection *ovirtsdk.Connection, vmId string, diskId string) (*ovirtsdk.DiskAttachment, error) {
 	vmService := connection.SystemService().VmsService().VmService(vmId)
-	attachments, err := vmService.DiskAttachmentsService().List().Send()
+	attachments, err := vmService.DiskAttachmentsService().List().Header(ovirt.CorrelationHeader(ctx)).Send()
 	if err != nil {
 		return nil, err
 	}
@@ -18,5 +71,176 @@ func diskAttachmentByVmAndDisk(connection *ovirtsdk.Connection, vmId string, dis
 			return attachment, nil
 		}
 	}
-	return nil, fmt.Errorf("failed to find attachment by disk %s for VM %s", diskId, vmId)
+	return nil, status.Errorf(codes.NotFound, "failed to find attachment by disk %s for VM %s", diskId, vmId)
+}
+
+// diskByName returns the disk with the exact name, with its storage domain
+// followed, or nil if there is none
+func diskByName(ctx context.Context, connection *ovirtsdk.Connection, name string) (*ovirtsdk.Disk, error) {
+	disks, err := connection.SystemService().DisksService().
+		List().
+		Search("name=" + name).
+		Follow("storage_domains").
+		Header(ovirt.CorrelationHeader(ctx)).
+		Send()
+	if err != nil {
+		return nil, err
+	}
+	for _, disk := range disks.MustDisks().Slice() {
+		if disk.MustName() == name {
+			return disk, nil
+		}
+	}
+	return nil, nil
+}
+
+// driverDisks returns the disks created by the driver, with their storage
+// domains followed. The search matches the description loosely, so it is
+// compared again.
+func driverDisks(ctx context.Context, connection *ovirtsdk.Connection) ([]*ovirtsdk.Disk, error) {
+	disks, err := connection.SystemService().DisksService().
+		List().
+		Search("description=" + diskDescription).
+		Follow("storage_domains").
+		Header(ovirt.CorrelationHeader(ctx)).
+		Send()
+	if err != nil {
+		return nil, err
+	}
+
+	var result []*ovirtsdk.Disk
+	for _, disk := range disks.MustDisks().Slice() {
+		if description, ok := disk.Description(); ok && description == diskDescription {
+			result = append(result, disk)
+		}
+	}
+	return result, nil
+}
+
+// driverVolumeIds maps the IDs of the disks created by the driver to their volume IDs
+func driverVolumeIds(ctx context.Context, connection *ovirtsdk.Connection) (map[string]string, error) {
+	disks, err := driverDisks(ctx, connection)
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string]string, len(disks))
+	for _, disk := range disks {
+		result[disk.MustId()] = diskVolumeHandle(disk).String()
+	}
+	return result, nil
+}
+
+// vmsByDisk returns the VMs the disk is attached to. oVirt has no search
+// by disk, so the attachments of every VM are followed in a single request.
+func vmsByDisk(ctx context.Context, connection *ovirtsdk.Connection, diskId string) ([]*ovirtsdk.Vm, error) {
+	vms, err := connection.SystemService().VmsService().List().Follow("disk_attachments").Header(ovirt.CorrelationHeader(ctx)).Send()
+	if err != nil {
+		return nil, err
+	}
+
+	var result []*ovirtsdk.Vm
+	for _, vm := range vms.MustVms().Slice() {
+		attachments, ok := vm.DiskAttachments()
+		if !ok {
+			continue
+		}
+		for _, attachment := range attachments.Slice() {
+			if diskId == attachment.MustDisk().MustId() {
+				result = append(result, vm)
+				break
+			}
+		}
+	}
+	return result, nil
+}
+
+// vmIdsByDisk maps the ID of every attached disk to the IDs of the VMs it is attached to
+func vmIdsByDisk(ctx context.Context, connection *ovirtsdk.Connection) (map[string][]string, error) {
+	vms, err := connection.SystemService().VmsService().List().Follow("disk_attachments").Header(ovirt.CorrelationHeader(ctx)).Send()
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(map[string][]string)
+	for _, vm := range vms.MustVms().Slice() {
+		attachments, ok := vm.DiskAttachments()
+		if !ok {
+			continue
+		}
+		for _, attachment := range attachments.Slice() {
+			diskId := attachment.MustDisk().MustId()
+			result[diskId] = append(result[diskId], vm.MustId())
+		}
+	}
+	return result, nil
+}
+
+// paginate resolves a CSI starting token and max entries into the bounds of
+// the page within total entries, and the token of the next page.
+func paginate(startingToken string, maxEntries int32, total int) (int, int, string, error) {
+	start := 0
+	if startingToken != "" {
+		var err error
+		start, err = strconv.Atoi(startingToken)
+		if err != nil || start < 0 || start > total {
+			return 0, 0, "", status.Errorf(codes.Aborted, "invalid starting token %s", startingToken)
+		}
+	}
+
+	end := total
+	if maxEntries > 0 && start+int(maxEntries) < total {
+		end = start + int(maxEntries)
+	}
+
+	nextToken := ""
+	if end < total {
+		nextToken = strconv.Itoa(end)
+	}
+	return start, end, nextToken, nil
+}
+
+// extendDisk grows the provisioned size of a disk which is not attached to any VM
+func extendDisk(ctx context.Context, connection *ovirtsdk.Connection, diskId string, size int64) error {
+	_, err := connection.SystemService().DisksService().DiskService(diskId).
+		Update().
+		Disk(ovirtsdk.NewDiskBuilder().ProvisionedSize(size).MustBuild()).
+		Header(ovirt.CorrelationHeader(ctx)).
+		Send()
+	return err
+}
+
+// growDisk extends the disk, which is not attached to any VM, to the required size
+// unless it is that large already, and returns it once the engine unlocks it
+func growDisk(ctx context.Context, connection *ovirtsdk.Connection, disk *ovirtsdk.Disk, requiredBytes int64) (*ovirtsdk.Disk, error) {
+	capacity := disk.MustProvisionedSize()
+	if requiredBytes <= capacity {
+		return disk, nil
+	}
+	klog.Infof("Extending disk %s from %d to %d", disk.MustId(), capacity, requiredBytes)
+	if err := extendDisk(ctx, connection, disk.MustId(), requiredBytes); err != nil {
+		return nil, err
+	}
+	// the disk is locked until it is extended
+	return ovirt.WaitForDisk(ctx, connection, disk.MustId())
+}
+
+// extendAttachedDisk grows the provisioned size of a disk through its attachment
+// to the VM, so that the guest is notified of the new size
+func extendAttachedDisk(ctx context.Context, connection *ovirtsdk.Connection, vmId string, diskId string, size int64) error {
+	attachment, err := diskAttachmentByVmAndDisk(ctx, connection, vmId, diskId)
+	if err != nil {
+		return err
+	}
+
+	_, err = connection.SystemService().VmsService().VmService(vmId).
+		DiskAttachmentsService().
+		AttachmentService(attachment.MustId()).
+		Update().
+		DiskAttachment(
+			ovirtsdk.NewDiskAttachmentBuilder().
+				DiskBuilder(ovirtsdk.NewDiskBuilder().ProvisionedSize(size)).
+				MustBuild()).
+		Header(ovirt.CorrelationHeader(ctx)).
+		Send()
+	return err
 }
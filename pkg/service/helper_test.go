@@ -0,0 +1,79 @@
+package service
+
+import (
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	ovirtsdk "github.com/ovirt/go-ovirt"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var _ = Describe("Pagination", func() {
+	DescribeTable("pages entries",
+		func(startingToken string, maxEntries int32, total int, start int, end int, nextToken string) {
+			s, e, next, err := paginate(startingToken, maxEntries, total)
+			Expect(err).NotTo(HaveOccurred())
+			Expect([]interface{}{s, e, next}).To(Equal([]interface{}{start, end, nextToken}))
+		},
+		Entry("everything", "", int32(0), 5, 0, 5, ""),
+		Entry("first page", "", int32(2), 5, 0, 2, "2"),
+		Entry("middle page", "2", int32(2), 5, 2, 4, "4"),
+		Entry("last page", "4", int32(2), 5, 4, 5, ""),
+		Entry("past the last entry", "5", int32(2), 5, 5, 5, ""),
+		Entry("nothing", "", int32(2), 0, 0, 0, ""),
+	)
+
+	DescribeTable("rejects invalid starting tokens",
+		func(startingToken string) {
+			_, _, _, err := paginate(startingToken, 2, 5)
+			Expect(status.Code(err)).To(Equal(codes.Aborted))
+		},
+		Entry("not a number", "abc"),
+		Entry("negative", "-1"),
+		Entry("beyond the entries", "6"),
+	)
+})
+
+var _ = Describe("Volume capabilities", func() {
+	disk := func(shareable bool, readOnly bool) *ovirtsdk.Disk {
+		return ovirtsdk.NewDiskBuilder().Id("disk").Shareable(shareable).ReadOnly(readOnly).MustBuild()
+	}
+	mount := func(mode csi.VolumeCapability_AccessMode_Mode, fsType string) *csi.VolumeCapability {
+		return &csi.VolumeCapability{
+			AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{FsType: fsType}},
+			AccessMode: &csi.VolumeCapability_AccessMode{Mode: mode},
+		}
+	}
+	block := func(mode csi.VolumeCapability_AccessMode_Mode) *csi.VolumeCapability {
+		return &csi.VolumeCapability{
+			AccessType: &csi.VolumeCapability_Block{Block: &csi.VolumeCapability_BlockVolume{}},
+			AccessMode: &csi.VolumeCapability_AccessMode{Mode: mode},
+		}
+	}
+
+	DescribeTable("supports capabilities the disk allows",
+		func(disk *ovirtsdk.Disk, capability *csi.VolumeCapability) {
+			Expect(unsupportedCapability(disk, capability)).To(BeEmpty())
+		},
+		Entry("single node writer", disk(false, false), mount(csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER, "ext4")),
+		Entry("read only disk read by a single node", disk(false, true), mount(csi.VolumeCapability_AccessMode_SINGLE_NODE_READER_ONLY, "ext4")),
+		Entry("shareable disk read by several nodes", disk(true, false), mount(csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY, "xfs")),
+		Entry("shareable block volume written by several nodes", disk(true, false), block(csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER)),
+		Entry("clustered filesystem written by several nodes", disk(true, false), mount(csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER, "gfs2")),
+	)
+
+	DescribeTable("rejects capabilities the disk does not allow",
+		func(disk *ovirtsdk.Disk, capability *csi.VolumeCapability) {
+			Expect(unsupportedCapability(disk, capability)).NotTo(BeEmpty())
+		},
+		Entry("missing access mode", disk(false, false), mount(csi.VolumeCapability_AccessMode_UNKNOWN, "ext4")),
+		Entry("missing access type", disk(false, false), &csi.VolumeCapability{
+			AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+		}),
+		Entry("several nodes on a disk which is not shareable", disk(false, false), block(csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY)),
+		Entry("writer on a read only disk", disk(false, true), mount(csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER, "ext4")),
+		Entry("local filesystem written by several nodes", disk(true, false), mount(csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER, "ext4")),
+	)
+})
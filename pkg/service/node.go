@@ -4,10 +4,13 @@ import (
 	"bufio"
 	"bytes"
 	"errors"
+	"io/ioutil"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"syscall"
 
 	"k8s.io/utils/mount"
 
@@ -15,6 +18,8 @@ import (
 	"github.com/ovirt/csi-driver/internal/ovirt"
 	ovirtsdk "github.com/ovirt/go-ovirt"
 	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 	"k8s.io/klog"
 )
 
@@ -23,8 +28,14 @@ type NodeService struct {
 	ovirtClient *ovirt.Client
 }
 
+// defaultFsType is the filesystem created when the volume capability does not name one
+const defaultFsType = "ext4"
+
 var NodeCaps = []csi.NodeServiceCapability_RPC_Type{
 	csi.NodeServiceCapability_RPC_STAGE_UNSTAGE_VOLUME,
+	csi.NodeServiceCapability_RPC_EXPAND_VOLUME,
+	csi.NodeServiceCapability_RPC_GET_VOLUME_STATS,
+	csi.NodeServiceCapability_RPC_VOLUME_CONDITION,
 }
 
 func baseDevicePathByInterface(diskInterface ovirtsdk.DiskInterface) (string, error) {
@@ -37,15 +48,21 @@ func baseDevicePathByInterface(diskInterface ovirtsdk.DiskInterface) (string, er
 	return "", errors.New("device type is unsupported")
 }
 
-func (n *NodeService) NodeStageVolume(_ context.Context, req *csi.NodeStageVolumeRequest) (*csi.NodeStageVolumeResponse, error) {
+func (n *NodeService) NodeStageVolume(ctx context.Context, req *csi.NodeStageVolumeRequest) (*csi.NodeStageVolumeResponse, error) {
 	klog.Infof("Staging volume %s with %+v", req.VolumeId, req)
+	if req.VolumeCapability.GetBlock() != nil {
+		// a block volume is published as the raw device, there is nothing to stage
+		klog.Infof("Volume %s is a block volume, skipping filesystem creation", req.VolumeId)
+		return &csi.NodeStageVolumeResponse{}, nil
+	}
+
 	conn, err := n.ovirtClient.GetConnection()
 	if err != nil {
 		klog.Errorf("Failed to get ovirt client connection")
 		return nil, err
 	}
 
-	device, err := getDeviceByAttachmentId(req.VolumeId, n.nodeId, conn)
+	device, err := getDeviceByAttachmentId(ctx, req.VolumeId, n.nodeId, conn)
 	if err != nil {
 		klog.Errorf("Failed to fetch device by attachment-id for volume %s on node %s", req.VolumeId, n.nodeId)
 		return nil, err
@@ -57,17 +74,40 @@ func (n *NodeService) NodeStageVolume(_ context.Context, req *csi.NodeStageVolum
 		klog.Errorf("Failed to fetch device info for volume %s on node %s", req.VolumeId, n.nodeId)
 		return nil, err
 	}
+	fsType := req.VolumeCapability.GetMount().FsType
+	if fsType == "" {
+		fsType = defaultFsType
+	}
 	if filesystem != "" {
-		klog.Infof("Detected fs %s, returning", filesystem)
+		klog.Infof("Detected fs %s", filesystem)
+		fsType = filesystem
+	} else {
+		// no filesystem - create it
+		klog.Infof("Creating FS %s on device %s", fsType, device)
+		err = makeFS(device, fsType)
+		if err != nil {
+			klog.Errorf("Could not create filesystem %s on %s", fsType, device)
+			return nil, err
+		}
+	}
+
+	stagingPath := req.GetStagingTargetPath()
+	err = os.MkdirAll(stagingPath, 0750)
+	if err != nil {
+		return nil, errors.New(err.Error())
+	}
+	if isMountpoint(stagingPath) {
+		klog.Infof("Volume %s is already staged on %s", req.VolumeId, stagingPath)
 		return &csi.NodeStageVolumeResponse{}, nil
 	}
 
-	fsType := req.VolumeCapability.GetMount().FsType
-	// no filesystem - create it
-	klog.Infof("Creating FS %s on device %s", fsType, device)
-	err = makeFS(device, fsType)
+	mountFlags := req.VolumeCapability.GetMount().GetMountFlags()
+	klog.Infof("Mounting devicePath %s, on stagingPath: %s with FS type: %s and options: %v",
+		device, stagingPath, fsType, mountFlags)
+	mounter := mount.New("")
+	err = mounter.Mount(device, stagingPath, fsType, mountFlags)
 	if err != nil {
-		klog.Errorf("Could not create filesystem %s on %s", fsType, device)
+		klog.Errorf("Failed mounting %v", err)
 		return nil, err
 	}
 
@@ -75,33 +115,58 @@ func (n *NodeService) NodeStageVolume(_ context.Context, req *csi.NodeStageVolum
 }
 
 func (n *NodeService) NodeUnstageVolume(ctx context.Context, req *csi.NodeUnstageVolumeRequest) (*csi.NodeUnstageVolumeResponse, error) {
+	mounter := mount.New("")
+	klog.Infof("Unmounting %s", req.GetStagingTargetPath())
+	err := mount.CleanupMountPoint(req.GetStagingTargetPath(), mounter, true)
+	if err != nil {
+		klog.Infof("Failed to unmount")
+		return nil, err
+	}
+
 	return &csi.NodeUnstageVolumeResponse{}, nil
 }
 
 func (n *NodeService) NodePublishVolume(ctx context.Context, req *csi.NodePublishVolumeRequest) (*csi.NodePublishVolumeResponse, error) {
-	conn, err := n.ovirtClient.GetConnection()
-	if err != nil {
-		klog.Errorf("Failed to get ovirt client connection")
-		return nil, err
-	}
+	targetPath := req.GetTargetPath()
+	if req.VolumeCapability.GetBlock() != nil {
+		conn, err := n.ovirtClient.GetConnection()
+		if err != nil {
+			klog.Errorf("Failed to get ovirt client connection")
+			return nil, err
+		}
 
-	device, err := getDeviceByAttachmentId(req.VolumeId, n.nodeId, conn)
-	if err != nil {
-		klog.Errorf("Failed to fetch device by attachment-id for volume %s on node %s", req.VolumeId, n.nodeId)
-		return nil, err
+		device, err := getDeviceByAttachmentId(ctx, req.VolumeId, n.nodeId, conn)
+		if err != nil {
+			klog.Errorf("Failed to fetch device by attachment-id for volume %s on node %s", req.VolumeId, n.nodeId)
+			return nil, err
+		}
+
+		err = publishBlockDevice(device, targetPath, req.Readonly)
+		if err != nil {
+			klog.Errorf("Failed publishing block device %s on %s: %v", device, targetPath, err)
+			return nil, err
+		}
+		return &csi.NodePublishVolumeResponse{}, nil
 	}
 
-	targetPath := req.GetTargetPath()
-	err = os.MkdirAll(targetPath, 0750)
+	err := os.MkdirAll(targetPath, 0750)
 	if err != nil {
 		return nil, errors.New(err.Error())
 	}
+	if isMountpoint(targetPath) {
+		klog.Infof("Volume %s is already published on %s", req.VolumeId, targetPath)
+		return &csi.NodePublishVolumeResponse{}, nil
+	}
 
-	fsType := req.VolumeCapability.GetMount().FsType
-	klog.Infof("Mounting devicePath %s, on targetPath: %s with FS type: %s",
-		device, targetPath, fsType)
+	options := append([]string{"bind"}, req.VolumeCapability.GetMount().GetMountFlags()...)
+	if req.Readonly {
+		options = append(options, "ro")
+	}
+
+	stagingPath := req.GetStagingTargetPath()
+	klog.Infof("Bind mounting stagingPath %s, on targetPath: %s with options: %v", stagingPath, targetPath, options)
 	mounter := mount.New("")
-	err = mounter.Mount(device, targetPath, fsType, []string{})
+	err = mounter.Mount(stagingPath, targetPath, "", options)
 	if err != nil {
 		klog.Errorf("Failed mounting %v", err)
 		return nil, err
@@ -113,7 +178,8 @@ func (n *NodeService) NodePublishVolume(ctx context.Context, req *csi.NodePublis
 func (n *NodeService) NodeUnpublishVolume(ctx context.Context, req *csi.NodeUnpublishVolumeRequest) (*csi.NodeUnpublishVolumeResponse, error) {
 	mounter := mount.New("")
 	klog.Infof("Unmounting %s", req.GetTargetPath())
-	err := mounter.Unmount(req.GetTargetPath())
+	// removes the target path as well, a directory for filesystems and a file for block devices
+	err := mount.CleanupMountPoint(req.GetTargetPath(), mounter, true)
 	if err != nil {
 		klog.Infof("Failed to unmount")
 		return nil, err
@@ -122,16 +188,152 @@ func (n *NodeService) NodeUnpublishVolume(ctx context.Context, req *csi.NodeUnpu
 	return &csi.NodeUnpublishVolumeResponse{}, nil
 }
 
-func (n *NodeService) NodeGetVolumeStats(context.Context, *csi.NodeGetVolumeStatsRequest) (*csi.NodeGetVolumeStatsResponse, error) {
-	panic("implement me")
+// NodeGetVolumeStats reports the usage of a filesystem volume, or the capacity of a block volume.
+// A volume path which is no longer mounted, or whose filesystem failed, is reported abnormal in
+// the volume condition. The usage of a volume which is not mounted is unknown.
+func (n *NodeService) NodeGetVolumeStats(_ context.Context, req *csi.NodeGetVolumeStatsRequest) (*csi.NodeGetVolumeStatsResponse, error) {
+	if req.VolumeId == "" || req.VolumePath == "" {
+		return nil, status.Error(codes.InvalidArgument, "volume id and volume path are required")
+	}
+
+	info, err := os.Stat(req.VolumePath)
+	if os.IsNotExist(err) {
+		return nil, status.Errorf(codes.NotFound, "volume %s does not exist on %s", req.VolumeId, req.VolumePath)
+	}
+	if errors.Is(err, syscall.EIO) {
+		return unreadableVolumeStats(err), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	mountPoint, err := mountPointByPath(req.VolumePath)
+	if err != nil {
+		return nil, err
+	}
+	if mountPoint == nil {
+		// the path holds whatever is below it, its usage is not the volume's
+		return &csi.NodeGetVolumeStatsResponse{
+			VolumeCondition: &csi.VolumeCondition{Abnormal: true, Message: "volume is not mounted on " + req.VolumePath},
+		}, nil
+	}
+
+	// an abnormal volume still reports its usage, kubelet turns the condition into an event
+	condition := &csi.VolumeCondition{}
+	if abnormal := filesystemErrors(mountPoint); abnormal != "" {
+		condition = &csi.VolumeCondition{Abnormal: true, Message: abnormal}
+	}
+
+	if info.Mode()&os.ModeDevice != 0 {
+		size, err := blockDeviceSize(req.VolumePath)
+		if err != nil {
+			klog.Errorf("Failed to get the size of block volume %s on %s", req.VolumeId, req.VolumePath)
+			return nil, err
+		}
+		return &csi.NodeGetVolumeStatsResponse{
+			Usage: []*csi.VolumeUsage{
+				{Unit: csi.VolumeUsage_BYTES, Total: size},
+			},
+			VolumeCondition: condition,
+		}, nil
+	}
+
+	var statfs syscall.Statfs_t
+	err = syscall.Statfs(req.VolumePath, &statfs)
+	if errors.Is(err, syscall.EIO) {
+		return unreadableVolumeStats(err), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	blockSize := int64(statfs.Bsize)
+	return &csi.NodeGetVolumeStatsResponse{
+		Usage: []*csi.VolumeUsage{
+			{
+				Unit:      csi.VolumeUsage_BYTES,
+				Available: int64(statfs.Bavail) * blockSize,
+				Total:     int64(statfs.Blocks) * blockSize,
+				Used:      int64(statfs.Blocks-statfs.Bfree) * blockSize,
+			},
+			{
+				Unit:      csi.VolumeUsage_INODES,
+				Available: int64(statfs.Ffree),
+				Total:     int64(statfs.Files),
+				Used:      int64(statfs.Files - statfs.Ffree),
+			},
+		},
+		VolumeCondition: condition,
+	}, nil
+}
+
+// unreadableVolumeStats reports a volume whose filesystem fails with I/O errors.
+// The usage is unknown, but the condition is all the more important.
+func unreadableVolumeStats(err error) *csi.NodeGetVolumeStatsResponse {
+	return &csi.NodeGetVolumeStatsResponse{
+		VolumeCondition: &csi.VolumeCondition{Abnormal: true, Message: "filesystem is unreadable: " + err.Error()},
+	}
 }
 
-func (n *NodeService) NodeExpandVolume(context.Context, *csi.NodeExpandVolumeRequest) (*csi.NodeExpandVolumeResponse, error) {
-	panic("implement me")
+func (n *NodeService) NodeExpandVolume(ctx context.Context, req *csi.NodeExpandVolumeRequest) (*csi.NodeExpandVolumeResponse, error) {
+	klog.Infof("Expanding volume %s on node %s", req.VolumeId, n.nodeId)
+	conn, err := n.ovirtClient.GetConnection()
+	if err != nil {
+		klog.Errorf("Failed to get ovirt client connection")
+		return nil, err
+	}
+
+	device, err := getDeviceByAttachmentId(ctx, req.VolumeId, n.nodeId, conn)
+	if err != nil {
+		klog.Errorf("Failed to fetch device by attachment-id for volume %s on node %s", req.VolumeId, n.nodeId)
+		return nil, err
+	}
+
+	err = rescanDevice(device)
+	if err != nil {
+		klog.Errorf("Failed to rescan device %s", device)
+		return nil, err
+	}
+
+	if req.VolumeCapability.GetBlock() != nil {
+		return &csi.NodeExpandVolumeResponse{CapacityBytes: req.CapacityRange.GetRequiredBytes()}, nil
+	}
+
+	filesystem, err := getDeviceInfo(device)
+	if err != nil {
+		klog.Errorf("Failed to fetch device info for volume %s on node %s", req.VolumeId, n.nodeId)
+		return nil, err
+	}
+
+	mountPath := req.StagingTargetPath
+	if mountPath == "" || !isMountpoint(mountPath) {
+		mountPath = req.VolumePath
+	}
+
+	klog.Infof("Resizing FS %s on device %s mounted on %s", filesystem, device, mountPath)
+	err = resizeFS(device, mountPath, filesystem)
+	if err != nil {
+		klog.Errorf("Could not resize filesystem %s on %s", filesystem, device)
+		return nil, err
+	}
+
+	return &csi.NodeExpandVolumeResponse{CapacityBytes: req.CapacityRange.GetRequiredBytes()}, nil
 }
 
-func (n *NodeService) NodeGetInfo(context.Context, *csi.NodeGetInfoRequest) (*csi.NodeGetInfoResponse, error) {
-	return &csi.NodeGetInfoResponse{NodeId: n.nodeId}, nil
+func (n *NodeService) NodeGetInfo(ctx context.Context, _ *csi.NodeGetInfoRequest) (*csi.NodeGetInfoResponse, error) {
+	conn, err := n.ovirtClient.GetConnection()
+	if err != nil {
+		klog.Errorf("Failed to get ovirt client connection")
+		return nil, err
+	}
+
+	topology, err := nodeTopology(ctx, conn, n.nodeId)
+	if err != nil {
+		klog.Errorf("Failed to fetch the cluster and data center of node %s", n.nodeId)
+		return nil, err
+	}
+
+	return &csi.NodeGetInfoResponse{NodeId: n.nodeId, AccessibleTopology: topology}, nil
 }
 
 func (n *NodeService) NodeGetCapabilities(context.Context, *csi.NodeGetCapabilitiesRequest) (*csi.NodeGetCapabilitiesResponse, error) {
@@ -151,19 +353,18 @@ func (n *NodeService) NodeGetCapabilities(context.Context, *csi.NodeGetCapabilit
 	return &csi.NodeGetCapabilitiesResponse{Capabilities: caps}, nil
 }
 
-func getDeviceByAttachmentId(volumeID, nodeID string, conn *ovirtsdk.Connection) (string, error) {
-	attachment, err := diskAttachmentByVmAndDisk(conn, nodeID, volumeID)
+func getDeviceByAttachmentId(ctx context.Context, volumeID, nodeID string, conn *ovirtsdk.Connection) (string, error) {
+	// the disk ID is part of the volume handle, there is no need to follow the attachment to the disk
+	handle, err := parseVolumeHandle(volumeID)
 	if err != nil {
-		return "", err
+		return "", status.Errorf(codes.NotFound, "volume %s does not exist: %v", volumeID, err)
 	}
+	diskId := handle.diskId
 
-	klog.Infof("Extracting pvc volume name %s", volumeID)
-	disk, _ := conn.FollowLink(attachment.MustDisk())
-	d, ok := disk.(*ovirtsdk.Disk)
-	if !ok {
-		return "", errors.New("couldn't retrieve disk from attachment")
+	attachment, err := diskAttachmentByVmAndDisk(ctx, conn, nodeID, diskId)
+	if err != nil {
+		return "", err
 	}
-	klog.Infof("Extracted disk ID from PVC %s", d.MustId())
 
 	baseDevicePath, err := baseDevicePathByInterface(attachment.MustInterface())
 	if err != nil {
@@ -171,7 +372,7 @@ func getDeviceByAttachmentId(volumeID, nodeID string, conn *ovirtsdk.Connection)
 	}
 
 	// verify the device path exists
-	device := baseDevicePath + d.MustId()
+	device := baseDevicePath + diskId
 	_, err = os.Stat(device)
 	if err == nil {
 		klog.Infof("Device path %s exists", device)
@@ -180,17 +381,43 @@ func getDeviceByAttachmentId(volumeID, nodeID string, conn *ovirtsdk.Connection)
 
 	if os.IsNotExist(err) {
 		// try with short disk ID, where the serial ID is only 20 chars long (controlled by udev)
-		shortDevice := baseDevicePath + d.MustId()[:20]
+		shortDevice := baseDevicePath + diskId[:20]
 		_, err = os.Stat(shortDevice)
 		if err == nil {
 			klog.Infof("Device path %s exists", shortDevice)
 			return shortDevice, nil
 		}
 	}
-	klog.Errorf("Device path for disk ID %s does not exists", d.MustId())
+	klog.Errorf("Device path for disk ID %s does not exists", diskId)
 	return "", errors.New("device was not found")
 }
 
+// publishBlockDevice bind mounts the device node onto a file at the target path
+func publishBlockDevice(device string, targetPath string, readOnly bool) error {
+	err := os.MkdirAll(filepath.Dir(targetPath), 0750)
+	if err != nil {
+		return errors.New(err.Error())
+	}
+
+	file, err := os.OpenFile(targetPath, os.O_CREATE, 0660)
+	if err != nil {
+		return errors.New(err.Error())
+	}
+	file.Close()
+
+	if isMountpoint(targetPath) {
+		klog.Infof("Block device %s is already published on %s", device, targetPath)
+		return nil
+	}
+
+	options := []string{"bind"}
+	if readOnly {
+		options = append(options, "ro")
+	}
+	klog.Infof("Bind mounting block device %s on %s with options: %v", device, targetPath, options)
+	return mount.New("").Mount(device, targetPath, "", options)
+}
+
 // getDeviceInfo will return the first Device which is a partition and its filesystem.
 // if the given Device disk has no partition then an empty zero valued device will return
 func getDeviceInfo(device string) (string, error) {
@@ -221,12 +448,13 @@ func makeFS(device string, fsType string) error {
 	// caution, use -F to force creating the filesystem if it doesn't exit. May not be portable for fs other
 	// than ext family
 	klog.Infof("Mounting device %s, with FS %s", device, fsType)
-	var force string
+	args := []string{"-t", fsType}
 	if strings.HasPrefix(fsType, "ext") {
-		force = "-F"
+		args = append(args, "-F")
 	}
+	args = append(args, device)
 
-	cmd := exec.Command("mkfs", force, "-t", fsType, device)
+	cmd := exec.Command("mkfs", args...)
 	err := cmd.Run()
 	exitError, incompleteCmd := err.(*exec.ExitError)
 	if err != nil && incompleteCmd {
@@ -236,6 +464,102 @@ func makeFS(device string, fsType string) error {
 	return nil
 }
 
+// rescanDevice makes the kernel re-read the size of a SCSI device. virtio-blk devices
+// are notified of the new size by the hypervisor and have nothing to rescan.
+func rescanDevice(device string) error {
+	devicePath, err := filepath.EvalSymlinks(device)
+	if err != nil {
+		klog.Errorf("Unable to evaluate symlink for device %s", device)
+		return errors.New(err.Error())
+	}
+
+	rescanPath := filepath.Join("/sys/class/block", filepath.Base(devicePath), "device", "rescan")
+	if _, err := os.Stat(rescanPath); os.IsNotExist(err) {
+		return nil
+	}
+
+	klog.Infof("Rescanning device %s", devicePath)
+	return ioutil.WriteFile(rescanPath, []byte("1"), 0200)
+}
+
+// resizeFS grows the filesystem to the size of the device. ext filesystems are
+// resized through the device and xfs through its mount path.
+func resizeFS(device string, mountPath string, fsType string) error {
+	var cmd *exec.Cmd
+	switch {
+	case strings.HasPrefix(fsType, "ext"):
+		cmd = exec.Command("resize2fs", device)
+	case fsType == "xfs":
+		cmd = exec.Command("xfs_growfs", mountPath)
+	default:
+		return errors.New("resizing filesystem " + fsType + " is unsupported")
+	}
+
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return errors.New(err.Error() + " " + cmd.Args[0] + " failed with " + string(out))
+	}
+
+	return nil
+}
+
+// mountPointByPath returns the mount on the path, or nil if nothing is mounted there
+func mountPointByPath(path string) (*mount.MountPoint, error) {
+	mountPoints, err := mount.New("").List()
+	if err != nil {
+		return nil, err
+	}
+	for i := range mountPoints {
+		if mountPoints[i].Path == path {
+			return &mountPoints[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// filesystemErrors tells why a mount is read-only because its filesystem hit I/O
+// errors, which ext filesystems do with errors=remount-ro. It is empty for a healthy mount.
+func filesystemErrors(mountPoint *mount.MountPoint) string {
+	readOnly := false
+	for _, opt := range mountPoint.Opts {
+		if opt == "ro" {
+			readOnly = true
+		}
+	}
+	if !readOnly || !strings.HasPrefix(mountPoint.Type, "ext") {
+		return ""
+	}
+
+	devicePath, err := filepath.EvalSymlinks(mountPoint.Device)
+	if err != nil {
+		return ""
+	}
+	errorsCount, err := ioutil.ReadFile(filepath.Join("/sys/fs/ext4", filepath.Base(devicePath), "errors_count"))
+	if err != nil {
+		return ""
+	}
+	count := strings.TrimSpace(string(errorsCount))
+	if count == "0" {
+		return ""
+	}
+	return "filesystem is read-only after " + count + " errors"
+}
+
+// blockDeviceSize returns the size in bytes of a block device
+func blockDeviceSize(device string) (int64, error) {
+	cmd := exec.Command("blockdev", "--getsize64", device)
+	out, err := cmd.Output()
+	exitError, incompleteCmd := err.(*exec.ExitError)
+	if err != nil && incompleteCmd {
+		return 0, errors.New(err.Error() + " blockdev failed with " + string(exitError.Stderr))
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	return strconv.ParseInt(strings.TrimSpace(string(out)), 10, 64)
+}
+
 // isMountpoint find out if a given directory is a real mount point
 func isMountpoint(mountDir string) bool {
 	cmd := exec.Command("findmnt", mountDir)
@@ -0,0 +1,228 @@
+package service
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/ovirt/csi-driver/internal/ovirt"
+	ovirtsdk "github.com/ovirt/go-ovirt"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// reservedParameterPrefix is the prefix of the parameters the sidecars add on
+// their own, e.g. the PVC name with --extra-create-metadata
+const reservedParameterPrefix = "csi.storage.k8s.io/"
+
+// volumeParameters are the StorageClass parameters of a volume
+type volumeParameters struct {
+	storageDomainName string
+	selection         string
+	label             string
+	sparse            bool
+	shareable         bool
+	overcommitRatio   float64
+	format            ovirtsdk.DiskFormat
+	diskInterface     ovirtsdk.DiskInterface
+	diskProfileName   string
+	diskProfileId     string
+	quotaName         string
+	quotaId           string
+}
+
+// parseVolumeParameters validates the StorageClass parameters. Every error
+// is InvalidArgument and names the offending key.
+func parseVolumeParameters(parameters map[string]string) (*volumeParameters, error) {
+	p := &volumeParameters{overcommitRatio: 1, diskInterface: ovirtsdk.DISKINTERFACE_VIRTIO_SCSI}
+	sparse := make(map[string]bool)
+	for key, value := range parameters {
+		var err error
+		switch key {
+		case ParameterStorageDomainName:
+			if value == "" {
+				return nil, status.Errorf(codes.InvalidArgument, "parameter %s must not be empty", key)
+			}
+			p.storageDomainName = value
+		case ParameterStorageDomainSelection:
+			p.selection = value
+		case ParameterStorageDomainLabel:
+			p.label = value
+		case ParameterThinProvisioning, ParameterSparse:
+			p.sparse, err = strconv.ParseBool(value)
+			sparse[key] = p.sparse
+		case ParameterFormat:
+			p.format = ovirtsdk.DiskFormat(value)
+			if p.format != ovirtsdk.DISKFORMAT_RAW && p.format != ovirtsdk.DISKFORMAT_COW {
+				return nil, status.Errorf(codes.InvalidArgument,
+					"parameter %s must be %s or %s, got %q", key, ovirtsdk.DISKFORMAT_RAW, ovirtsdk.DISKFORMAT_COW, value)
+			}
+		case ParameterInterface:
+			p.diskInterface = ovirtsdk.DiskInterface(value)
+			if _, err := baseDevicePathByInterface(p.diskInterface); err != nil {
+				return nil, status.Errorf(codes.InvalidArgument,
+					"parameter %s must be %s or %s, got %q", key, ovirtsdk.DISKINTERFACE_VIRTIO, ovirtsdk.DISKINTERFACE_VIRTIO_SCSI, value)
+			}
+		case ParameterDiskProfileName:
+			p.diskProfileName = value
+		case ParameterDiskProfileId:
+			p.diskProfileId = value
+		case ParameterQuotaName:
+			p.quotaName = value
+		case ParameterQuotaId:
+			p.quotaId = value
+		case ParameterShareable:
+			p.shareable, err = strconv.ParseBool(value)
+		case ParameterOvercommitRatio:
+			p.overcommitRatio, err = strconv.ParseFloat(value, 64)
+			if err == nil && p.overcommitRatio < 1 {
+				return nil, status.Errorf(codes.InvalidArgument, "parameter %s must be at least 1, got %q", key, value)
+			}
+		default:
+			if !strings.HasPrefix(key, reservedParameterPrefix) {
+				return nil, status.Errorf(codes.InvalidArgument, "unknown parameter %s", key)
+			}
+		}
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "parameter %s has an invalid value %q: %v", key, value, err)
+		}
+	}
+
+	switch p.selection {
+	case "", SelectionMostFreeSpace, SelectionRoundRobin:
+	case SelectionLabel:
+		if p.label == "" {
+			return nil, status.Errorf(codes.InvalidArgument,
+				"parameter %s is required by the %s selection policy", ParameterStorageDomainLabel, SelectionLabel)
+		}
+	default:
+		return nil, status.Errorf(codes.InvalidArgument,
+			"parameter %s has an unknown policy %q", ParameterStorageDomainSelection, p.selection)
+	}
+
+	// sparse is the new name of thinProvisioning, they may not contradict each other
+	if len(sparse) == 2 && sparse[ParameterSparse] != sparse[ParameterThinProvisioning] {
+		return nil, status.Errorf(codes.InvalidArgument,
+			"parameter %s contradicts parameter %s", ParameterSparse, ParameterThinProvisioning)
+	}
+
+	if p.diskProfileName != "" && p.diskProfileId != "" {
+		return nil, status.Errorf(codes.InvalidArgument,
+			"parameter %s may not be given along with parameter %s", ParameterDiskProfileId, ParameterDiskProfileName)
+	}
+
+	if p.quotaName != "" && p.quotaId != "" {
+		return nil, status.Errorf(codes.InvalidArgument,
+			"parameter %s may not be given along with parameter %s", ParameterQuotaId, ParameterQuotaName)
+	}
+
+	// oVirt shares only raw disks
+	switch {
+	case p.format == "" && p.shareable:
+		p.format = ovirtsdk.DISKFORMAT_RAW
+	case p.format == "":
+		p.format = ovirtsdk.DISKFORMAT_COW
+	case p.format == ovirtsdk.DISKFORMAT_COW && p.shareable:
+		return nil, status.Errorf(codes.InvalidArgument,
+			"parameter %s must be %s for %s disks", ParameterFormat, ovirtsdk.DISKFORMAT_RAW, ParameterShareable)
+	}
+
+	// overcommit only applies to thin disks
+	if !p.sparse {
+		p.overcommitRatio = 1
+	}
+	return p, nil
+}
+
+// validateDiskLayout checks the format and allocation against the type of
+// the storage domain. Block domains cannot hold sparse raw disks.
+func validateDiskLayout(sd *ovirtsdk.StorageDomain, p *volumeParameters) error {
+	if p.format != ovirtsdk.DISKFORMAT_RAW || !p.sparse {
+		return nil
+	}
+	storage, ok := sd.Storage()
+	if !ok {
+		return nil
+	}
+	if storageType, _ := storage.Type(); storageType == ovirtsdk.STORAGETYPE_ISCSI || storageType == ovirtsdk.STORAGETYPE_FCP {
+		return status.Errorf(codes.InvalidArgument,
+			"parameter %s cannot be true for %s disks on the %s storage domain %s",
+			ParameterSparse, ovirtsdk.DISKFORMAT_RAW, storageType, sd.MustName())
+	}
+	return nil
+}
+
+// diskVolumeContext describes how the disk was created, so that later calls
+// such as ControllerPublishVolume act on the volume the same way. It also
+// exposes the effective disk profile of the disk.
+func diskVolumeContext(
+	ctx context.Context, connection *ovirtsdk.Connection, disk *ovirtsdk.Disk, placement *volumePlacement, diskInterface ovirtsdk.DiskInterface) (map[string]string, error) {
+
+	format, _ := disk.Format()
+	sparse, _ := disk.Sparse()
+	volumeContext := map[string]string{
+		ParameterStorageDomainName: placement.storageDomain.MustName(),
+		ParameterFormat:            string(format),
+		ParameterSparse:            strconv.FormatBool(sparse),
+		ParameterInterface:         string(diskInterface),
+	}
+
+	profile, err := diskProfileOf(ctx, connection, placement.storageDomain.MustId(), disk)
+	if err != nil {
+		return nil, err
+	}
+	if err := addDiskProfileContext(ctx, connection, volumeContext, placement, profile); err != nil {
+		return nil, err
+	}
+	return volumeContext, nil
+}
+
+// validateStorageDomain checks that the storage domain named in the parameters
+// exists and holds data, so that a typo is not mistaken for a full domain.
+func validateStorageDomain(ctx context.Context, connection *ovirtsdk.Connection, p *volumeParameters) error {
+	if p.storageDomainName == "" {
+		return nil
+	}
+	storageDomains, err := connection.SystemService().StorageDomainsService().
+		List().
+		Search("name=" + p.storageDomainName).
+		Header(ovirt.CorrelationHeader(ctx)).
+		Send()
+	if err != nil {
+		return err
+	}
+	for _, sd := range storageDomains.MustStorageDomains().Slice() {
+		if sd.MustName() != p.storageDomainName {
+			continue
+		}
+		if sdType, _ := sd.Type(); sdType != ovirtsdk.STORAGEDOMAINTYPE_DATA {
+			return status.Errorf(codes.InvalidArgument,
+				"parameter %s: storage domain %s is a %s domain, not a data domain", ParameterStorageDomainName, p.storageDomainName, sdType)
+		}
+		return nil
+	}
+	return status.Errorf(codes.InvalidArgument,
+		"parameter %s: storage domain %s does not exist", ParameterStorageDomainName, p.storageDomainName)
+}
+
+// volumeSize is the size to create a volume with, the required bytes or else
+// the limit of the capacity range.
+func volumeSize(capacityRange *csi.CapacityRange) (int64, error) {
+	required := capacityRange.GetRequiredBytes()
+	limit := capacityRange.GetLimitBytes()
+	if required < 0 || limit < 0 {
+		return 0, status.Errorf(codes.InvalidArgument, "capacity_range must not be negative, got %v", capacityRange)
+	}
+	if required == 0 {
+		required = limit
+	}
+	if required == 0 {
+		return 0, status.Error(codes.InvalidArgument, "capacity_range.required_bytes must be set and above zero")
+	}
+	if limit > 0 && required > limit {
+		return 0, status.Errorf(codes.InvalidArgument,
+			"capacity_range.required_bytes %d is above capacity_range.limit_bytes %d", required, limit)
+	}
+	return required, nil
+}
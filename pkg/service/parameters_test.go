@@ -0,0 +1,98 @@
+package service
+
+import (
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	ovirtsdk "github.com/ovirt/go-ovirt"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var _ = Describe("Volume parameters", func() {
+	DescribeTable("parses storage class parameters",
+		func(parameters map[string]string, expected volumeParameters) {
+			p, err := parseVolumeParameters(parameters)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(*p).To(Equal(expected))
+		},
+		Entry("defaults", map[string]string{},
+			volumeParameters{format: ovirtsdk.DISKFORMAT_COW, diskInterface: ovirtsdk.DISKINTERFACE_VIRTIO_SCSI, overcommitRatio: 1}),
+		Entry("thin provisioning as sparse", map[string]string{ParameterThinProvisioning: "true", ParameterOvercommitRatio: "2.5"},
+			volumeParameters{sparse: true, format: ovirtsdk.DISKFORMAT_COW, diskInterface: ovirtsdk.DISKINTERFACE_VIRTIO_SCSI, overcommitRatio: 2.5}),
+		Entry("agreeing sparse and thin provisioning", map[string]string{ParameterThinProvisioning: "true", ParameterSparse: "true"},
+			volumeParameters{sparse: true, format: ovirtsdk.DISKFORMAT_COW, diskInterface: ovirtsdk.DISKINTERFACE_VIRTIO_SCSI, overcommitRatio: 1}),
+		Entry("overcommit of a preallocated disk", map[string]string{ParameterSparse: "false", ParameterOvercommitRatio: "3"},
+			volumeParameters{format: ovirtsdk.DISKFORMAT_COW, diskInterface: ovirtsdk.DISKINTERFACE_VIRTIO_SCSI, overcommitRatio: 1}),
+		Entry("raw shareable disk", map[string]string{ParameterShareable: "true"},
+			volumeParameters{shareable: true, format: ovirtsdk.DISKFORMAT_RAW, diskInterface: ovirtsdk.DISKINTERFACE_VIRTIO_SCSI, overcommitRatio: 1}),
+		Entry("reserved parameters of the provisioner", map[string]string{"csi.storage.k8s.io/pvc/name": "data", ParameterInterface: "virtio"},
+			volumeParameters{format: ovirtsdk.DISKFORMAT_COW, diskInterface: ovirtsdk.DISKINTERFACE_VIRTIO, overcommitRatio: 1}),
+	)
+
+	DescribeTable("rejects invalid storage class parameters",
+		func(parameters map[string]string) {
+			_, err := parseVolumeParameters(parameters)
+			Expect(status.Code(err)).To(Equal(codes.InvalidArgument))
+		},
+		Entry("unknown key", map[string]string{"storageDomain": "data"}),
+		Entry("contradicting sparse and thin provisioning", map[string]string{ParameterThinProvisioning: "true", ParameterSparse: "false"}),
+		Entry("invalid boolean", map[string]string{ParameterShareable: "yes please"}),
+		Entry("overcommit below 1", map[string]string{ParameterSparse: "true", ParameterOvercommitRatio: "0.5"}),
+		Entry("empty storage domain", map[string]string{ParameterStorageDomainName: ""}),
+		Entry("unknown format", map[string]string{ParameterFormat: "qcow2"}),
+		Entry("unknown interface", map[string]string{ParameterInterface: "ide"}),
+		Entry("unknown selection policy", map[string]string{ParameterStorageDomainSelection: "random"}),
+		Entry("label policy without a label", map[string]string{ParameterStorageDomainSelection: SelectionLabel}),
+		Entry("disk profile by name and id", map[string]string{ParameterDiskProfileName: "gold", ParameterDiskProfileId: "1"}),
+		Entry("quota by name and id", map[string]string{ParameterQuotaName: "team", ParameterQuotaId: "1"}),
+		Entry("cow shareable disk", map[string]string{ParameterShareable: "true", ParameterFormat: "cow"}),
+	)
+
+	DescribeTable("sizes volumes from the capacity range",
+		func(capacityRange *csi.CapacityRange, expected int64) {
+			size, err := volumeSize(capacityRange)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(size).To(Equal(expected))
+		},
+		Entry("required bytes", &csi.CapacityRange{RequiredBytes: gib, LimitBytes: 2 * gib}, int64(gib)),
+		Entry("limit without required bytes", &csi.CapacityRange{LimitBytes: 2 * gib}, int64(2*gib)),
+	)
+
+	DescribeTable("rejects invalid capacity ranges",
+		func(capacityRange *csi.CapacityRange) {
+			_, err := volumeSize(capacityRange)
+			Expect(status.Code(err)).To(Equal(codes.InvalidArgument))
+		},
+		Entry("missing", nil),
+		Entry("zero", &csi.CapacityRange{}),
+		Entry("negative", &csi.CapacityRange{RequiredBytes: -1}),
+		Entry("required above limit", &csi.CapacityRange{RequiredBytes: 2 * gib, LimitBytes: gib}),
+	)
+})
+
+var _ = Describe("Disk layout", func() {
+	storageDomain := func(storageType ovirtsdk.StorageType) *ovirtsdk.StorageDomain {
+		return ovirtsdk.NewStorageDomainBuilder().
+			Name("data").
+			StorageBuilder(ovirtsdk.NewHostStorageBuilder().Type(storageType)).
+			MustBuild()
+	}
+
+	DescribeTable("checks the format and allocation against the storage domain",
+		func(storageType ovirtsdk.StorageType, format ovirtsdk.DiskFormat, sparse bool, valid bool) {
+			err := validateDiskLayout(storageDomain(storageType), &volumeParameters{format: format, sparse: sparse})
+			if valid {
+				Expect(err).NotTo(HaveOccurred())
+			} else {
+				Expect(status.Code(err)).To(Equal(codes.InvalidArgument))
+			}
+		},
+		Entry("sparse raw on file storage", ovirtsdk.STORAGETYPE_NFS, ovirtsdk.DISKFORMAT_RAW, true, true),
+		Entry("sparse cow on block storage", ovirtsdk.STORAGETYPE_ISCSI, ovirtsdk.DISKFORMAT_COW, true, true),
+		Entry("preallocated raw on block storage", ovirtsdk.STORAGETYPE_FCP, ovirtsdk.DISKFORMAT_RAW, false, true),
+		Entry("sparse raw on iSCSI", ovirtsdk.STORAGETYPE_ISCSI, ovirtsdk.DISKFORMAT_RAW, true, false),
+		Entry("sparse raw on FCP", ovirtsdk.STORAGETYPE_FCP, ovirtsdk.DISKFORMAT_RAW, true, false),
+	)
+})
@@ -0,0 +1,101 @@
+package service
+
+import (
+	"github.com/ovirt/csi-driver/internal/ovirt"
+	ovirtsdk "github.com/ovirt/go-ovirt"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog"
+)
+
+// unlimitedQuota is the storage limit of a quota without a limit
+const unlimitedQuota = -1
+
+// resolveQuota finds the quota of the parameters, by id or by name, among the
+// quotas of the data center the volume is placed in. It returns nil when the
+// parameters name none.
+func resolveQuota(ctx context.Context, connection *ovirtsdk.Connection, placement *volumePlacement, p *volumeParameters) (*ovirtsdk.Quota, error) {
+	if p.quotaName == "" && p.quotaId == "" {
+		return nil, nil
+	}
+	quotas, err := connection.SystemService().DataCentersService().DataCenterService(placement.dataCenter.MustId()).
+		QuotasService().
+		List().
+		Header(ovirt.CorrelationHeader(ctx)).
+		Send()
+	if err != nil {
+		return nil, err
+	}
+	for _, quota := range quotas.MustQuotas().Slice() {
+		if (p.quotaId != "" && quota.MustId() == p.quotaId) || (p.quotaId == "" && quota.MustName() == p.quotaName) {
+			return quota, nil
+		}
+	}
+
+	if p.quotaId != "" {
+		return nil, status.Errorf(codes.InvalidArgument,
+			"parameter %s: quota %s does not exist in data center %s", ParameterQuotaId, p.quotaId, placement.dataCenter.MustName())
+	}
+	return nil, status.Errorf(codes.InvalidArgument,
+		"parameter %s: quota %s does not exist in data center %s", ParameterQuotaName, p.quotaName, placement.dataCenter.MustName())
+}
+
+// quotaError turns the engine refusing a disk of the quota of the placement
+// for lack of space into ResourceExhausted, telling what is left of the quota
+// on the storage domain. Other errors are returned as they are.
+func quotaError(ctx context.Context, connection *ovirtsdk.Connection, placement *volumePlacement, err error) error {
+	if placement.quota == nil || !ovirt.IsExhausted(err) {
+		return err
+	}
+
+	remaining, ok, lookupErr := remainingQuota(ctx, connection, placement)
+	if lookupErr != nil {
+		klog.Errorf("Failed to look up the storage limit of quota %s: %v", placement.quota.MustName(), lookupErr)
+	}
+	if lookupErr != nil || !ok {
+		return status.Errorf(codes.ResourceExhausted, "quota %s is exhausted: %v", placement.quota.MustName(), err)
+	}
+	return status.Errorf(codes.ResourceExhausted, "quota %s has %.2f GiB left on storage domain %s: %v",
+		placement.quota.MustName(), remaining, placement.storageDomain.MustName(), err)
+}
+
+// remainingQuota returns the GiB left of the storage limit of the quota on the
+// storage domain, which is either its own limit or the limit of all domains.
+// It reports false when the quota has no limit there.
+func remainingQuota(ctx context.Context, connection *ovirtsdk.Connection, placement *volumePlacement) (float64, bool, error) {
+	limits, err := connection.SystemService().DataCentersService().DataCenterService(placement.dataCenter.MustId()).
+		QuotasService().
+		QuotaService(placement.quota.MustId()).
+		QuotaStorageLimitsService().
+		List().
+		Header(ovirt.CorrelationHeader(ctx)).
+		Send()
+	if err != nil {
+		return 0, false, err
+	}
+
+	var selected *ovirtsdk.QuotaStorageLimit
+	for _, limit := range limits.MustLimits().Slice() {
+		sd, ok := limit.StorageDomain()
+		if !ok && selected == nil {
+			selected = limit
+		} else if ok && sd.MustId() == placement.storageDomain.MustId() {
+			selected = limit
+		}
+	}
+	if selected == nil {
+		return 0, false, nil
+	}
+
+	limit, _ := selected.Limit()
+	if limit == unlimitedQuota {
+		return 0, false, nil
+	}
+	usage, _ := selected.Usage()
+	remaining := float64(limit) - usage
+	if remaining < 0 {
+		remaining = 0
+	}
+	return remaining, true, nil
+}
@@ -6,10 +6,15 @@ import (
 	"net/url"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/kubernetes-csi/csi-lib-utils/protosanitizer"
+	"github.com/ovirt/csi-driver/internal/ovirt"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
 	"k8s.io/klog"
 
 	csi "github.com/container-storage-interface/spec/lib/go/csi"
@@ -118,10 +123,67 @@ func (s *nonBlockingGRPCServer) serve(endpoint string, ids csi.IdentityServer, c
 
 }
 
+// correlationSuffixes name the RPCs in the correlation ids sent to the engine
+var correlationSuffixes = map[string]string{
+	"CreateVolume":               "create",
+	"DeleteVolume":               "delete",
+	"ControllerPublishVolume":    "attach",
+	"ControllerUnpublishVolume":  "detach",
+	"ValidateVolumeCapabilities": "validate",
+	"ListVolumes":                "list",
+	"GetCapacity":                "capacity",
+	"CreateSnapshot":             "snapshot",
+	"DeleteSnapshot":             "delsnap",
+	"ListSnapshots":              "listsnap",
+	"ControllerExpandVolume":     "expand",
+	"NodeStageVolume":            "stage",
+	"NodeUnstageVolume":          "unstage",
+	"NodePublishVolume":          "publish",
+	"NodeUnpublishVolume":        "unpublish",
+	"NodeGetVolumeStats":         "stats",
+	"NodeExpandVolume":           "nodeexpand",
+	"NodeGetInfo":                "info",
+}
+
+// correlationId derives the correlation id of the engine calls of an RPC from
+// the disk, the volume name or the snapshot it acts on. RPCs acting on no
+// single object are told apart by the time they were called.
+func correlationId(method string, req interface{}) string {
+	method = method[strings.LastIndex(method, "/")+1:]
+	suffix, ok := correlationSuffixes[method]
+	if !ok {
+		suffix = strings.ToLower(method)
+	}
+
+	var object string
+	switch r := req.(type) {
+	case interface{ GetVolumeId() string }:
+		object = r.GetVolumeId()
+		if handle, err := parseVolumeHandle(object); err == nil {
+			object = handle.diskId
+		}
+	case interface{ GetSnapshotId() string }:
+		object = r.GetSnapshotId()
+	case interface{ GetName() string }:
+		object = r.GetName()
+	}
+	if object == "" {
+		object = strconv.FormatInt(time.Now().UnixNano(), 36)
+	}
+	return ovirt.CorrelationId(object, suffix)
+}
+
 func logGRPC(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
-	klog.V(4).Infof("%s called with request: %+v", info.FullMethod, protosanitizer.StripSecrets(req))
+	id := correlationId(info.FullMethod, req)
+	ctx = ovirt.WithCorrelationId(ctx, id)
+	klog.V(4).Infof("%s called with correlation id %s and request: %+v", info.FullMethod, id, protosanitizer.StripSecrets(req))
 	resp, err := handler(ctx, req)
 	if err != nil {
+		// the sidecars retry by the code, so engine faults are translated to
+		// theirs, and the correlation id finds the engine side of the failure
+		// in engine.log and the events
+		s, _ := status.FromError(ovirt.Error(err))
+		err = status.Errorf(s.Code(), "%s (correlation id %s)", s.Message(), id)
 		klog.Errorf("%s returned with error: %v", info.FullMethod, err)
 	} else {
 		klog.V(4).Infof("%s returned with response: %+v", info.FullMethod, protosanitizer.StripSecrets(resp))
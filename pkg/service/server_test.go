@@ -0,0 +1,34 @@
+package service
+
+import (
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Request correlation id", func() {
+	DescribeTable("derives the correlation id from the object of the request",
+		func(method string, req interface{}, expected string) {
+			Expect(correlationId(method, req)).To(Equal(expected))
+		},
+		Entry("volume name", "/csi.v1.Controller/CreateVolume", &csi.CreateVolumeRequest{Name: "pvc-1"}, "pvc-1-create"),
+		Entry("bare disk id", "/csi.v1.Controller/DeleteVolume", &csi.DeleteVolumeRequest{VolumeId: "disk"}, "disk-delete"),
+		Entry("disk of a volume handle", "/csi.v1.Controller/ControllerPublishVolume",
+			&csi.ControllerPublishVolumeRequest{VolumeId: "v1/dc/sd/disk", NodeId: "vm"}, "disk-attach"),
+		Entry("malformed volume id", "/csi.v1.Node/NodeStageVolume", &csi.NodeStageVolumeRequest{VolumeId: "a/b"}, "a_b-stage"),
+		Entry("snapshot", "/csi.v1.Controller/DeleteSnapshot", &csi.DeleteSnapshotRequest{SnapshotId: "vm/snap/disk"}, "vm_snap_disk-delsnap"),
+		Entry("snapshot name", "/csi.v1.Controller/CreateSnapshot",
+			&csi.CreateSnapshotRequest{Name: "snapshot-1", SourceVolumeId: "disk"}, "snapshot-1-snapshot"),
+	)
+
+	DescribeTable("names requests without an object by the time",
+		func(method string, req interface{}, suffix string) {
+			id := correlationId(method, req)
+			Expect(id).To(HaveSuffix("-" + suffix))
+			Expect(len(id)).To(BeNumerically(">", len(suffix)+1))
+		},
+		Entry("known method", "/csi.v1.Controller/GetCapacity", &csi.GetCapacityRequest{}, "capacity"),
+		Entry("unknown method", "/csi.v1.Identity/Probe", &csi.ProbeRequest{}, "probe"),
+	)
+})
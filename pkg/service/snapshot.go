@@ -0,0 +1,232 @@
+package service
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/golang/protobuf/ptypes"
+	"github.com/ovirt/csi-driver/internal/ovirt"
+	ovirtsdk "github.com/ovirt/go-ovirt"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog"
+)
+
+// snapshotHandle identifies a CSI snapshot. oVirt snapshots disks only as part
+// of a VM snapshot, so the handle carries the VM and its snapshot along with
+// the disk the snapshot was taken from.
+type snapshotHandle struct {
+	vmId       string
+	snapshotId string
+	diskId     string
+}
+
+func (h snapshotHandle) String() string {
+	return strings.Join([]string{h.vmId, h.snapshotId, h.diskId}, "/")
+}
+
+func parseSnapshotHandle(id string) (snapshotHandle, error) {
+	parts := strings.Split(id, "/")
+	if len(parts) != 3 || parts[0] == "" || parts[1] == "" || parts[2] == "" {
+		return snapshotHandle{}, fmt.Errorf("malformed snapshot id %s", id)
+	}
+	return snapshotHandle{vmId: parts[0], snapshotId: parts[1], diskId: parts[2]}, nil
+}
+
+// snapshotDisk returns the image of the disk captured by the snapshot. The
+// snapshot must be fetched with its disks followed.
+func snapshotDisk(snapshot *ovirtsdk.Snapshot, diskId string) (*ovirtsdk.Disk, bool) {
+	disks, ok := snapshot.Disks()
+	if !ok {
+		return nil, false
+	}
+	for _, disk := range disks.Slice() {
+		if id, ok := disk.Id(); ok && id == diskId {
+			return disk, true
+		}
+	}
+	return nil, false
+}
+
+// vmSnapshots lists the regular snapshots of the VM, with their disks.
+func vmSnapshots(ctx context.Context, connection *ovirtsdk.Connection, vmId string) ([]*ovirtsdk.Snapshot, error) {
+	snapshots, err := connection.SystemService().VmsService().VmService(vmId).
+		SnapshotsService().
+		List().
+		Follow("disks").
+		Header(ovirt.CorrelationHeader(ctx)).
+		Send()
+	if err != nil {
+		return nil, err
+	}
+
+	var result []*ovirtsdk.Snapshot
+	for _, snapshot := range snapshots.MustSnapshots().Slice() {
+		if snapshotType, ok := snapshot.SnapshotType(); ok && snapshotType == ovirtsdk.SNAPSHOTTYPE_REGULAR {
+			result = append(result, snapshot)
+		}
+	}
+	return result, nil
+}
+
+// snapshotByDescription finds the VM snapshot created for a CSI snapshot
+// name, which the driver stores as the snapshot description.
+func snapshotByDescription(ctx context.Context, connection *ovirtsdk.Connection, vmId string, description string) (*ovirtsdk.Snapshot, error) {
+	snapshots, err := vmSnapshots(ctx, connection, vmId)
+	if err != nil {
+		return nil, err
+	}
+	for _, snapshot := range snapshots {
+		if d, ok := snapshot.Description(); ok && d == description {
+			return snapshot, nil
+		}
+	}
+	return nil, nil
+}
+
+// csiSnapshots converts the VM snapshots to a CSI snapshot for each captured
+// disk, optionally limited to a single disk. The source volume IDs are looked
+// up by disk ID. Without a single disk, disks missing there are not the
+// driver's and are skipped, otherwise it is reported by the bare disk ID.
+func csiSnapshots(vmId string, snapshots []*ovirtsdk.Snapshot, diskId string, sourceIds map[string]string) ([]*csi.Snapshot, error) {
+	var result []*csi.Snapshot
+	for _, snapshot := range snapshots {
+		disks, ok := snapshot.Disks()
+		if !ok {
+			continue
+		}
+		for _, disk := range disks.Slice() {
+			if diskId != "" && disk.MustId() != diskId {
+				continue
+			}
+			handle := snapshotHandle{vmId: vmId, snapshotId: snapshot.MustId(), diskId: disk.MustId()}
+			source, ok := sourceIds[disk.MustId()]
+			if !ok && diskId == "" {
+				continue
+			}
+			if !ok {
+				source = disk.MustId()
+			}
+			s, err := csiSnapshot(handle, source, snapshot, disk.MustProvisionedSize())
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, s)
+		}
+	}
+	return result, nil
+}
+
+func csiSnapshot(handle snapshotHandle, sourceVolumeId string, snapshot *ovirtsdk.Snapshot, sizeBytes int64) (*csi.Snapshot, error) {
+	snapshotStatus, _ := snapshot.SnapshotStatus()
+	s := &csi.Snapshot{
+		SnapshotId:     handle.String(),
+		SourceVolumeId: sourceVolumeId,
+		SizeBytes:      sizeBytes,
+		ReadyToUse:     snapshotStatus == ovirtsdk.SNAPSHOTSTATUS_OK,
+	}
+	if date, ok := snapshot.Date(); ok {
+		creationTime, err := ptypes.TimestampProto(date)
+		if err != nil {
+			return nil, err
+		}
+		s.CreationTime = creationTime
+	}
+	return s, nil
+}
+
+// restoreVmName is the name of the temporary VM a snapshot is cloned into
+// when restoring it to the volume.
+func restoreVmName(volumeName string) string {
+	return "csi-restore-" + volumeName
+}
+
+// restoreVmId returns the ID of the temporary VM of a restore to the disk named
+// diskName which is in progress, or an empty string when there is none.
+func restoreVmId(ctx context.Context, connection *ovirtsdk.Connection, diskName string) (string, error) {
+	vms, err := connection.SystemService().VmsService().
+		List().
+		Search("name=" + restoreVmName(diskName)).
+		Header(ovirt.CorrelationHeader(ctx)).
+		Send()
+	if err != nil {
+		return "", err
+	}
+	if existing := vms.MustVms().Slice(); len(existing) > 0 {
+		return existing[0].MustId(), nil
+	}
+	return "", nil
+}
+
+// cloneSnapshot starts the restore of a disk snapshot to the disk. oVirt clones
+// snapshots only as part of a VM, so the snapshot is cloned into a temporary VM
+// of the cluster, whose ID is returned.
+func cloneSnapshot(
+	ctx context.Context, connection *ovirtsdk.Connection, handle snapshotHandle, cluster *ovirtsdk.Cluster, diskName string,
+	disk *ovirtsdk.Disk) (string, error) {
+
+	vmName := restoreVmName(diskName)
+	vm, err := ovirtsdk.NewVmBuilder().
+		Name(vmName).
+		Cluster(cluster).
+		TemplateBuilder(ovirtsdk.NewTemplateBuilder().Name("Blank")).
+		SnapshotsBuilderOfAny(*ovirtsdk.NewSnapshotBuilder().Id(handle.snapshotId)).
+		DiskAttachmentsOfAny(ovirtsdk.NewDiskAttachmentBuilder().Disk(disk).MustBuild()).
+		Build()
+	if err != nil {
+		return "", err
+	}
+
+	klog.Infof("Cloning snapshot %s to disk %s in VM %s", handle, diskName, vmName)
+	addVm, err := connection.SystemService().VmsService().Add().Vm(vm).Clone(true).Header(ovirt.CorrelationHeader(ctx)).Send()
+	if err != nil {
+		return "", err
+	}
+	return addVm.MustVm().MustId(), nil
+}
+
+// finishRestore waits for the temporary VM of a restore to clone the snapshot,
+// then detaches the disk named diskName from it and removes the VM. It is safe
+// to call again while a restore is in progress.
+func finishRestore(ctx context.Context, connection *ovirtsdk.Connection, handle snapshotHandle, vmId string, diskName string) (*ovirtsdk.Disk, error) {
+	vmService := connection.SystemService().VmsService().VmService(vmId)
+	if err := ovirt.WaitForVmImageUnlocked(ctx, connection, vmId); err != nil {
+		return nil, err
+	}
+
+	restoredDisk, err := diskByName(ctx, connection, diskName)
+	if err != nil {
+		return nil, err
+	}
+	if restoredDisk == nil {
+		return nil, fmt.Errorf("disk %s was not created by VM %s", diskName, restoreVmName(diskName))
+	}
+
+	// an interrupted restore may have detached the disk already, leaving only the VM to remove
+	attachment, err := diskAttachmentByVmAndDisk(ctx, connection, vmId, restoredDisk.MustId())
+	if err != nil && status.Code(err) != codes.NotFound {
+		return nil, err
+	}
+	if attachment != nil {
+		_, err = vmService.
+			DiskAttachmentsService().
+			AttachmentService(attachment.MustId()).
+			Remove().
+			DetachOnly(true).
+			Header(ovirt.CorrelationHeader(ctx)).
+			Send()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	_, err = vmService.Remove().Header(ovirt.CorrelationHeader(ctx)).Send()
+	if err != nil {
+		return nil, err
+	}
+
+	klog.Infof("Restored snapshot %s to disk %s", handle, restoredDisk.MustId())
+	return restoredDisk, nil
+}
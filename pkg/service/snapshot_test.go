@@ -0,0 +1,70 @@
+package service
+
+import (
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	ovirtsdk "github.com/ovirt/go-ovirt"
+)
+
+var _ = Describe("Snapshot handle", func() {
+	DescribeTable("parses snapshot ids",
+		func(id string, expected snapshotHandle) {
+			handle, err := parseSnapshotHandle(id)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(handle).To(Equal(expected))
+			Expect(handle.String()).To(Equal(id))
+		},
+		Entry("vm, snapshot and disk", "vm/snap/disk", snapshotHandle{vmId: "vm", snapshotId: "snap", diskId: "disk"}),
+	)
+
+	DescribeTable("rejects malformed snapshot ids",
+		func(id string) {
+			_, err := parseSnapshotHandle(id)
+			Expect(err).To(HaveOccurred())
+		},
+		Entry("empty", ""),
+		Entry("bare snapshot id", "snap"),
+		Entry("missing disk", "vm/snap"),
+		Entry("empty snapshot", "vm//disk"),
+		Entry("extra part", "vm/snap/disk/x"),
+	)
+})
+
+var _ = Describe("CSI snapshots", func() {
+	snapshots := []*ovirtsdk.Snapshot{
+		ovirtsdk.NewSnapshotBuilder().
+			Id("snap-1").
+			SnapshotStatus(ovirtsdk.SNAPSHOTSTATUS_OK).
+			DisksOfAny(
+				ovirtsdk.NewDiskBuilder().Id("disk-a").ProvisionedSize(gib).MustBuild(),
+				ovirtsdk.NewDiskBuilder().Id("disk-b").ProvisionedSize(2*gib).MustBuild()).
+			MustBuild(),
+		ovirtsdk.NewSnapshotBuilder().
+			Id("snap-2").
+			SnapshotStatus(ovirtsdk.SNAPSHOTSTATUS_LOCKED).
+			DisksOfAny(ovirtsdk.NewDiskBuilder().Id("disk-a").ProvisionedSize(gib).MustBuild()).
+			MustBuild(),
+		ovirtsdk.NewSnapshotBuilder().Id("snap-3").MustBuild(),
+	}
+
+	DescribeTable("converts the disks of VM snapshots",
+		func(diskId string, sourceIds map[string]string, expected []*csi.Snapshot) {
+			result, err := csiSnapshots("vm", snapshots, diskId, sourceIds)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result).To(Equal(expected))
+		},
+		Entry("disks of the driver only", "", map[string]string{"disk-a": "v1/dc/sd/disk-a"}, []*csi.Snapshot{
+			{SnapshotId: "vm/snap-1/disk-a", SourceVolumeId: "v1/dc/sd/disk-a", SizeBytes: gib, ReadyToUse: true},
+			{SnapshotId: "vm/snap-2/disk-a", SourceVolumeId: "v1/dc/sd/disk-a", SizeBytes: gib, ReadyToUse: false},
+		}),
+		Entry("single disk of the driver", "disk-b", map[string]string{"disk-b": "v1/dc/sd/disk-b"}, []*csi.Snapshot{
+			{SnapshotId: "vm/snap-1/disk-b", SourceVolumeId: "v1/dc/sd/disk-b", SizeBytes: 2 * gib, ReadyToUse: true},
+		}),
+		Entry("single disk by its bare id", "disk-b", map[string]string{}, []*csi.Snapshot{
+			{SnapshotId: "vm/snap-1/disk-b", SourceVolumeId: "disk-b", SizeBytes: 2 * gib, ReadyToUse: true},
+		}),
+		Entry("no disk of the driver", "", map[string]string{}, []*csi.Snapshot(nil)),
+	)
+})
@@ -0,0 +1,130 @@
+package service
+
+import (
+	"sort"
+	"strings"
+	"sync/atomic"
+
+	"github.com/ovirt/csi-driver/internal/ovirt"
+	ovirtsdk "github.com/ovirt/go-ovirt"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const gib = 1024 * 1024 * 1024
+
+// The policies for selecting a storage domain when the parameters do not name one
+const (
+	SelectionMostFreeSpace = "mostFreeSpace"
+	SelectionRoundRobin    = "roundRobin"
+	SelectionLabel         = "label"
+)
+
+// dataCentersByName returns the data center with the name, or all of them when
+// the name is empty.
+func dataCentersByName(ctx context.Context, connection *ovirtsdk.Connection, name string) ([]*ovirtsdk.DataCenter, error) {
+	request := connection.SystemService().DataCentersService().List()
+	if name != "" {
+		request.Search("name=" + name)
+	}
+	dataCenters, err := request.Header(ovirt.CorrelationHeader(ctx)).Send()
+	if err != nil {
+		return nil, err
+	}
+	return dataCenters.MustDataCenters().Slice(), nil
+}
+
+// activeDataDomains returns the data storage domains which are active in the
+// data center. The status of a storage domain is only known within its data
+// center, so they are listed through it.
+func activeDataDomains(ctx context.Context, connection *ovirtsdk.Connection, dataCenterId string) ([]*ovirtsdk.StorageDomain, error) {
+	storageDomains, err := connection.SystemService().DataCentersService().DataCenterService(dataCenterId).
+		StorageDomainsService().
+		List().
+		Header(ovirt.CorrelationHeader(ctx)).
+		Send()
+	if err != nil {
+		return nil, err
+	}
+
+	var result []*ovirtsdk.StorageDomain
+	for _, sd := range storageDomains.MustStorageDomains().Slice() {
+		sdType, _ := sd.Type()
+		sdStatus, _ := sd.Status()
+		if sdType == ovirtsdk.STORAGEDOMAINTYPE_DATA && sdStatus == ovirtsdk.STORAGEDOMAINSTATUS_ACTIVE {
+			result = append(result, sd)
+		}
+	}
+	return result, nil
+}
+
+// storageDomainCapacity is the space left for new disks on the storage domain.
+// The engine refuses to create disks below the critical space threshold, and
+// thin disks may overcommit what is left by the given ratio.
+func storageDomainCapacity(sd *ovirtsdk.StorageDomain, overcommitRatio float64) int64 {
+	available, _ := sd.Available()
+	blocker, _ := sd.CriticalSpaceActionBlocker()
+	available -= blocker * gib
+	if available < 0 {
+		return 0
+	}
+	return int64(float64(available) * overcommitRatio)
+}
+
+// selectStorageDomain picks one of the storage domains by the selection policy, which
+// defaults to the most free space. The label policy takes the storage domains
+// whose comment holds the label, and then the one of them with the most free space.
+// It returns nil when no storage domain fits.
+func (c *ControllerService) selectStorageDomain(storageDomains []*ovirtsdk.StorageDomain, p *volumeParameters) (*ovirtsdk.StorageDomain, error) {
+	switch p.selection {
+	case "", SelectionMostFreeSpace:
+	case SelectionRoundRobin:
+		if len(storageDomains) == 0 {
+			return nil, nil
+		}
+		sort.Slice(storageDomains, func(i, j int) bool {
+			return storageDomains[i].MustId() < storageDomains[j].MustId()
+		})
+		next := atomic.AddUint64(&c.storageDomainCounter, 1) - 1
+		return storageDomains[next%uint64(len(storageDomains))], nil
+	case SelectionLabel:
+		var labeled []*ovirtsdk.StorageDomain
+		for _, sd := range storageDomains {
+			if storageDomainAllowed(sd, p) {
+				labeled = append(labeled, sd)
+			}
+		}
+		storageDomains = labeled
+	default:
+		return nil, status.Errorf(codes.InvalidArgument, "unknown storage domain selection policy %q", p.selection)
+	}
+
+	var selected *ovirtsdk.StorageDomain
+	for _, sd := range storageDomains {
+		if selected == nil || storageDomainCapacity(sd, 1) > storageDomainCapacity(selected, 1) {
+			selected = sd
+		}
+	}
+	return selected, nil
+}
+
+// storageDomainAllowed tells whether the parameters let a volume go to the storage
+// domain: it must be the one they name, and hold the label of the label policy
+func storageDomainAllowed(sd *ovirtsdk.StorageDomain, p *volumeParameters) bool {
+	if p.storageDomainName != "" && sd.MustName() != p.storageDomainName {
+		return false
+	}
+	return p.selection != SelectionLabel || hasLabel(sd, p.label)
+}
+
+// hasLabel tells whether the label is one of the comma or space separated words of the storage domain comment
+func hasLabel(sd *ovirtsdk.StorageDomain, label string) bool {
+	comment, _ := sd.Comment()
+	for _, word := range strings.FieldsFunc(comment, func(r rune) bool { return r == ',' || r == ' ' }) {
+		if word == label {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,96 @@
+package service
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	ovirtsdk "github.com/ovirt/go-ovirt"
+)
+
+var _ = Describe("Storage domain capacity", func() {
+	DescribeTable("leaves out the critical space and overcommits thin disks",
+		func(available int64, blocker int64, overcommitRatio float64, expected int64) {
+			sd := ovirtsdk.NewStorageDomainBuilder().Available(available).CriticalSpaceActionBlocker(blocker).MustBuild()
+			Expect(storageDomainCapacity(sd, overcommitRatio)).To(Equal(expected))
+		},
+		Entry("above the critical space", int64(10*gib), int64(5), 1.0, int64(5*gib)),
+		Entry("overcommitted", int64(10*gib), int64(5), 2.0, int64(10*gib)),
+		Entry("below the critical space", int64(3*gib), int64(5), 2.0, int64(0)),
+		Entry("without a critical space", int64(gib), int64(0), 1.0, int64(gib)),
+	)
+})
+
+var _ = Describe("Storage domain selection", func() {
+	storageDomain := func(id string, availableGib int64, comment string) *ovirtsdk.StorageDomain {
+		return ovirtsdk.NewStorageDomainBuilder().Id(id).Available(availableGib * gib).Comment(comment).MustBuild()
+	}
+	storageDomains := func() []*ovirtsdk.StorageDomain {
+		return []*ovirtsdk.StorageDomain{
+			storageDomain("sd-b", 10, "fast"),
+			storageDomain("sd-a", 30, "slow, backup"),
+			storageDomain("sd-c", 20, "fast,ssd"),
+		}
+	}
+
+	DescribeTable("selects by the policy",
+		func(p volumeParameters, expected string) {
+			sd, err := (&ControllerService{}).selectStorageDomain(storageDomains(), &p)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(sd.MustId()).To(Equal(expected))
+		},
+		Entry("most free space by default", volumeParameters{}, "sd-a"),
+		Entry("most free space", volumeParameters{selection: SelectionMostFreeSpace}, "sd-a"),
+		Entry("most free space among the labeled", volumeParameters{selection: SelectionLabel, label: "fast"}, "sd-c"),
+	)
+
+	It("takes turns by id with the round robin policy", func() {
+		c := &ControllerService{}
+		p := &volumeParameters{selection: SelectionRoundRobin}
+		var selected []string
+		for i := 0; i < 4; i++ {
+			sd, err := c.selectStorageDomain(storageDomains(), p)
+			Expect(err).NotTo(HaveOccurred())
+			selected = append(selected, sd.MustId())
+		}
+		Expect(selected).To(Equal([]string{"sd-a", "sd-b", "sd-c", "sd-a"}))
+	})
+
+	DescribeTable("selects nothing when no storage domain fits",
+		func(storageDomains []*ovirtsdk.StorageDomain, p volumeParameters) {
+			sd, err := (&ControllerService{}).selectStorageDomain(storageDomains, &p)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(sd).To(BeNil())
+		},
+		Entry("no storage domain", []*ovirtsdk.StorageDomain{}, volumeParameters{}),
+		Entry("no storage domain to take turns", []*ovirtsdk.StorageDomain{}, volumeParameters{selection: SelectionRoundRobin}),
+		Entry("no label", storageDomains(), volumeParameters{selection: SelectionLabel, label: "archive"}),
+	)
+
+	DescribeTable("matches labels by whole words of the comment",
+		func(comment string, label string, expected bool) {
+			Expect(hasLabel(storageDomain("sd", 1, comment), label)).To(Equal(expected))
+		},
+		Entry("single word", "fast", "fast", true),
+		Entry("comma separated", "slow,fast", "fast", true),
+		Entry("comma and space separated", "slow, fast", "fast", true),
+		Entry("space separated", "slow fast", "fast", true),
+		Entry("part of a word", "faster", "fast", false),
+		Entry("no comment", "", "fast", false),
+	)
+})
+
+var _ = Describe("Allowed storage domains", func() {
+	sd := ovirtsdk.NewStorageDomainBuilder().Name("data").Comment("fast,ssd").MustBuild()
+
+	DescribeTable("follows the name and the label policy of the parameters",
+		func(p volumeParameters, expected bool) {
+			Expect(storageDomainAllowed(sd, &p)).To(Equal(expected))
+		},
+		Entry("any", volumeParameters{}, true),
+		Entry("named", volumeParameters{storageDomainName: "data"}, true),
+		Entry("another one named", volumeParameters{storageDomainName: "backup"}, false),
+		Entry("labeled", volumeParameters{selection: SelectionLabel, label: "ssd"}, true),
+		Entry("not labeled", volumeParameters{selection: SelectionLabel, label: "archive"}, false),
+		Entry("label without the label policy", volumeParameters{selection: SelectionMostFreeSpace, label: "archive"}, true),
+	)
+})
@@ -0,0 +1,212 @@
+package service
+
+import (
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/ovirt/csi-driver/internal/ovirt"
+	ovirtsdk "github.com/ovirt/go-ovirt"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// nodeTopology returns the cluster and the data center of the node VM. A disk is
+// reachable from every cluster of its data center, so volumes carry only the
+// data center segment.
+func nodeTopology(ctx context.Context, connection *ovirtsdk.Connection, vmId string) (*csi.Topology, error) {
+	cluster, err := vmCluster(ctx, connection, vmId)
+	if err != nil {
+		return nil, err
+	}
+
+	dataCenter, err := connection.SystemService().DataCentersService().
+		DataCenterService(cluster.MustDataCenter().MustId()).
+		Get().
+		Header(ovirt.CorrelationHeader(ctx)).
+		Send()
+	if err != nil {
+		return nil, err
+	}
+
+	return &csi.Topology{
+		Segments: map[string]string{
+			TopologyKeyCluster:    cluster.MustName(),
+			TopologyKeyDataCenter: dataCenter.MustDataCenter().MustName(),
+		},
+	}, nil
+}
+
+// vmCluster returns the cluster of the VM, which tells its data center
+func vmCluster(ctx context.Context, connection *ovirtsdk.Connection, vmId string) (*ovirtsdk.Cluster, error) {
+	vm, err := connection.SystemService().VmsService().VmService(vmId).Get().Header(ovirt.CorrelationHeader(ctx)).Send()
+	if err != nil {
+		return nil, err
+	}
+
+	cluster, err := connection.SystemService().ClustersService().
+		ClusterService(vm.MustVm().MustCluster().MustId()).
+		Get().
+		Header(ovirt.CorrelationHeader(ctx)).
+		Send()
+	if err != nil {
+		return nil, err
+	}
+	return cluster.MustCluster(), nil
+}
+
+// dataCentersOfSegments resolves the data centers a topology segment points to,
+// through the data center or else through the cluster. Without either, all
+// data centers are returned.
+func dataCentersOfSegments(ctx context.Context, connection *ovirtsdk.Connection, segments map[string]string) ([]*ovirtsdk.DataCenter, error) {
+	if name, ok := segments[TopologyKeyDataCenter]; ok {
+		return dataCentersByName(ctx, connection, name)
+	}
+
+	name, ok := segments[TopologyKeyCluster]
+	if !ok {
+		return dataCentersByName(ctx, connection, "")
+	}
+
+	clusters, err := connection.SystemService().ClustersService().List().Search("name=" + name).Header(ovirt.CorrelationHeader(ctx)).Send()
+	if err != nil {
+		return nil, err
+	}
+	var result []*ovirtsdk.DataCenter
+	for _, cluster := range clusters.MustClusters().Slice() {
+		dataCenter, ok := cluster.DataCenter()
+		if !ok {
+			continue
+		}
+		dc, err := connection.SystemService().DataCentersService().DataCenterService(dataCenter.MustId()).Get().Header(ovirt.CorrelationHeader(ctx)).Send()
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, dc.MustDataCenter())
+	}
+	return result, nil
+}
+
+// volumePlacement is the storage domain a new volume goes to, with its data center, disk profile
+// and quota, and the topology it is accessible from
+type volumePlacement struct {
+	storageDomain *ovirtsdk.StorageDomain
+	dataCenter    *ovirtsdk.DataCenter
+	diskProfile   *ovirtsdk.DiskProfile
+	quota         *ovirtsdk.Quota
+	topology      []*csi.Topology
+}
+
+// placeVolume picks the storage domain of a new volume among the active data domains of the data
+// centers of the accessibility requirements, preferred ones first, or of all data centers when there
+// are no requirements. A volume with a content source must stay in the data center of the source,
+// given by its ID, which is empty otherwise. The storage domain named in the parameters must be among
+// them, otherwise one is selected by the selection policy of the parameters.
+func (c *ControllerService) placeVolume(
+	ctx context.Context, connection *ovirtsdk.Connection, requirements *csi.TopologyRequirement, p *volumeParameters,
+	dataCenterId string) (*volumePlacement, error) {
+
+	var candidates []*csi.Topology
+	candidates = append(candidates, requirements.GetPreferred()...)
+	candidates = append(candidates, requirements.GetRequisite()...)
+	if len(candidates) == 0 {
+		// no requirements, any data center will do
+		candidates = []*csi.Topology{{}}
+	}
+
+	storageDomainName := p.storageDomainName
+	for _, candidate := range candidates {
+		dataCenters, err := dataCentersOfSegments(ctx, connection, candidate.GetSegments())
+		if err != nil {
+			return nil, err
+		}
+
+		var storageDomains []*ovirtsdk.StorageDomain
+		dataCenterOf := make(map[string]*ovirtsdk.DataCenter)
+		for _, dataCenter := range dataCenters {
+			if dataCenterId != "" && dataCenter.MustId() != dataCenterId {
+				continue
+			}
+			domains, err := activeDataDomains(ctx, connection, dataCenter.MustId())
+			if err != nil {
+				return nil, err
+			}
+			for _, sd := range domains {
+				if storageDomainAllowed(sd, p) {
+					storageDomains = append(storageDomains, sd)
+					dataCenterOf[sd.MustId()] = dataCenter
+				}
+			}
+		}
+
+		sd, err := c.selectStorageDomain(storageDomains, p)
+		if err != nil {
+			return nil, err
+		}
+		if sd == nil {
+			continue
+		}
+
+		placement := &volumePlacement{storageDomain: sd, dataCenter: dataCenterOf[sd.MustId()]}
+		if len(candidate.GetSegments()) > 0 {
+			placement.topology = []*csi.Topology{
+				{Segments: map[string]string{TopologyKeyDataCenter: placement.dataCenter.MustName()}},
+			}
+		}
+		return placement, nil
+	}
+
+	if dataCenterId != "" {
+		return nil, status.Errorf(codes.ResourceExhausted,
+			"no active data storage domain fits in data center %s of the content source and the accessibility requirements", dataCenterId)
+	}
+	if storageDomainName != "" {
+		return nil, status.Errorf(codes.ResourceExhausted,
+			"storage domain %s is not active in any data center of the accessibility requirements", storageDomainName)
+	}
+	return nil, status.Error(codes.ResourceExhausted,
+		"no active data storage domain fits in the data centers of the accessibility requirements")
+}
+
+// diskPlacement returns where an existing disk is placed: its storage domain,
+// which must have been followed, and the data center the domain is attached to.
+// With accessibility requirements the data center must be among those of the
+// requisite topologies, or the preferred ones when there are none, otherwise
+// nil is returned. The disk is one found by the name of a new volume, so one
+// without a storage domain, such as a direct LUN, is AlreadyExists.
+func diskPlacement(
+	ctx context.Context, connection *ovirtsdk.Connection, disk *ovirtsdk.Disk, requirements *csi.TopologyRequirement) (*volumePlacement, error) {
+
+	storageDomains, ok := disk.StorageDomains()
+	if !ok || len(storageDomains.Slice()) == 0 {
+		return nil, status.Errorf(codes.AlreadyExists, "disk %s already exists without a storage domain", disk.MustName())
+	}
+	sd := storageDomains.Slice()[0]
+	var dataCenterId string
+	if dataCenters, ok := sd.DataCenters(); ok && len(dataCenters.Slice()) > 0 {
+		dataCenterId = dataCenters.Slice()[0].MustId()
+	}
+	placement := &volumePlacement{storageDomain: sd, dataCenter: ovirtsdk.NewDataCenterBuilder().Id(dataCenterId).MustBuild()}
+
+	candidates := requirements.GetRequisite()
+	if len(candidates) == 0 {
+		candidates = requirements.GetPreferred()
+	}
+	if len(candidates) == 0 {
+		return placement, nil
+	}
+	for _, candidate := range candidates {
+		dataCenters, err := dataCentersOfSegments(ctx, connection, candidate.GetSegments())
+		if err != nil {
+			return nil, err
+		}
+		for _, dataCenter := range dataCenters {
+			if dataCenter.MustId() == dataCenterId {
+				placement.dataCenter = dataCenter
+				placement.topology = []*csi.Topology{
+					{Segments: map[string]string{TopologyKeyDataCenter: dataCenter.MustName()}},
+				}
+				return placement, nil
+			}
+		}
+	}
+	return nil, nil
+}
@@ -0,0 +1,63 @@
+package service
+
+import (
+	"fmt"
+	"strings"
+
+	ovirtsdk "github.com/ovirt/go-ovirt"
+)
+
+// volumeHandleVersion prefixes the volume handles, so that their format can change
+const volumeHandleVersion = "v1"
+
+// volumeHandle identifies a CSI volume. Besides the disk it names the data
+// center and the storage domain holding the disk, so that they need not be
+// looked up again. Volumes created by earlier versions of the driver are
+// identified by the bare disk id, which leaves the others empty.
+type volumeHandle struct {
+	dataCenterId    string
+	storageDomainId string
+	diskId          string
+}
+
+func newVolumeHandle(placement *volumePlacement, diskId string) volumeHandle {
+	return volumeHandle{
+		dataCenterId:    placement.dataCenter.MustId(),
+		storageDomainId: placement.storageDomain.MustId(),
+		diskId:          diskId,
+	}
+}
+
+// diskVolumeHandle returns the handle of the disk, from its storage domain,
+// which must have been followed
+func diskVolumeHandle(disk *ovirtsdk.Disk) volumeHandle {
+	handle := volumeHandle{diskId: disk.MustId()}
+	storageDomains, ok := disk.StorageDomains()
+	if !ok || len(storageDomains.Slice()) == 0 {
+		return handle
+	}
+	sd := storageDomains.Slice()[0]
+	handle.storageDomainId = sd.MustId()
+	if dataCenters, ok := sd.DataCenters(); ok && len(dataCenters.Slice()) > 0 {
+		handle.dataCenterId = dataCenters.Slice()[0].MustId()
+	}
+	return handle
+}
+
+func (h volumeHandle) String() string {
+	if h.dataCenterId == "" || h.storageDomainId == "" {
+		return h.diskId
+	}
+	return strings.Join([]string{volumeHandleVersion, h.dataCenterId, h.storageDomainId, h.diskId}, "/")
+}
+
+func parseVolumeHandle(id string) (volumeHandle, error) {
+	parts := strings.Split(id, "/")
+	switch {
+	case len(parts) == 1 && id != "":
+		return volumeHandle{diskId: id}, nil
+	case len(parts) == 4 && parts[0] == volumeHandleVersion && parts[1] != "" && parts[2] != "" && parts[3] != "":
+		return volumeHandle{dataCenterId: parts[1], storageDomainId: parts[2], diskId: parts[3]}, nil
+	}
+	return volumeHandle{}, fmt.Errorf("malformed volume id %s", id)
+}
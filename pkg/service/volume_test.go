@@ -0,0 +1,45 @@
+package service
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Volume handle", func() {
+	const diskId = "3f2b4c1e-9a7d-4e21-8c55-0d6f1b2a9e47"
+
+	DescribeTable("parses volume ids",
+		func(id string, expected volumeHandle) {
+			handle, err := parseVolumeHandle(id)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(handle).To(Equal(expected))
+		},
+		Entry("bare disk id of earlier versions", diskId, volumeHandle{diskId: diskId}),
+		Entry("v1 handle", "v1/dc/sd/"+diskId, volumeHandle{dataCenterId: "dc", storageDomainId: "sd", diskId: diskId}),
+	)
+
+	DescribeTable("rejects malformed volume ids",
+		func(id string) {
+			_, err := parseVolumeHandle(id)
+			Expect(err).To(HaveOccurred())
+		},
+		Entry("empty", ""),
+		Entry("unknown version", "v2/dc/sd/"+diskId),
+		Entry("missing storage domain", "v1/dc/"+diskId),
+		Entry("empty data center", "v1//sd/"+diskId),
+		Entry("empty disk", "v1/dc/sd/"),
+		Entry("extra part", "v1/dc/sd/"+diskId+"/x"),
+	)
+
+	DescribeTable("formats volume ids the parser reads back",
+		func(handle volumeHandle, expected string) {
+			Expect(handle.String()).To(Equal(expected))
+			parsed, err := parseVolumeHandle(handle.String())
+			Expect(err).NotTo(HaveOccurred())
+			Expect(parsed).To(Equal(handle))
+		},
+		Entry("with storage domain and data center", volumeHandle{dataCenterId: "dc", storageDomainId: "sd", diskId: diskId}, "v1/dc/sd/"+diskId),
+		Entry("with the disk only", volumeHandle{diskId: diskId}, diskId),
+	)
+})